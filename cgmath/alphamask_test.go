@@ -0,0 +1,76 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+// Opacity texture that is Below under the plane y = 0 and Above over it.
+type halfSpaceOpacity struct {
+    Below float64
+    Above float64
+}
+
+func (h *halfSpaceOpacity) Value(u float64, v float64, p *Vec3) Color {
+    if p.Y < 0 {
+        return Color{h.Below, h.Below, h.Below}
+    }
+    return Color{h.Above, h.Above, h.Above}
+}
+
+func TestAlphaMaskHit(t *testing.T) {
+    sphere := &Sphere{Center: Vec3{0, 0, 0}, Radius: 1}
+    // Straight down through the unit sphere, entering at t = 4 and leaving
+    // at t = 6.
+    ray := Ray{Orig: Vec3{0, 5, 0}, Dir: Vec3{0, -1, 0}}
+    tests := []struct {
+        name string
+        opacity halfSpaceOpacity
+        threshold float64
+        hit bool
+        t float64
+    }{
+        {"opaque", halfSpaceOpacity{1, 1}, 0.5, true, 4},
+        {"transparent top", halfSpaceOpacity{1, 0}, 0.5, true, 6},
+        {"below threshold", halfSpaceOpacity{0.4, 0.4}, 0.5, false, 0},
+        {"transparent", halfSpaceOpacity{0, 0}, 0.5, false, 0},
+        {"stochastic opaque", halfSpaceOpacity{1, 1}, 0, true, 4},
+        {"stochastic transparent", halfSpaceOpacity{0, 0}, 0, false, 0},
+    }
+    for _, tt := range tests {
+        opacity := tt.opacity
+        mask := MakeAlphaMask(sphere, &opacity, tt.threshold)
+        // A closer hit held by the caller must survive a miss.
+        rec := HitRecord{T: 2}
+        hit := mask.Hit(&ray, 0.001, 100, &rec)
+        if hit != tt.hit {
+            t.Errorf("%s: hit %v, want %v", tt.name, hit, tt.hit)
+            continue
+        }
+        if !hit && rec.T != 2 {
+            t.Errorf("%s: missing changed the record to t = %g", tt.name, rec.T)
+        }
+        if hit && math.Abs(rec.T - tt.t) > 1e-9 {
+            t.Errorf("%s: t = %g, want %g", tt.name, rec.T, tt.t)
+        }
+    }
+}
+
+func TestAlphaMaskStochastic(t *testing.T) {
+    sphere := &Sphere{Center: Vec3{0, 0, 0}, Radius: 1}
+    ray := Ray{Orig: Vec3{0, 5, 0}, Dir: Vec3{0, -1, 0}}
+    mask := MakeAlphaMask(sphere, &halfSpaceOpacity{0, 0.5}, 0)
+    const trials = 10000
+    hits := 0
+    for i := 0; i < trials; i++ {
+        var rec HitRecord
+        if mask.Hit(&ray, 0.001, 100, &rec) {
+            hits++
+        }
+    }
+    // Half of the rays stop on the top, the others pass through the fully
+    // transparent bottom.
+    if fraction := float64(hits) / trials; fraction < 0.47 || fraction > 0.53 {
+        t.Errorf("hit fraction %g, want 0.5", fraction)
+    }
+}
@@ -0,0 +1,120 @@
+package cgmath
+
+import (
+    "errors"
+    "math"
+)
+
+// Aperture is the shape of the opening of a lens, within the unit disk in
+// the xy plane. Out of focus highlights take its shape.
+type Aperture interface {
+    // Sample a point uniformly over the open part of the aperture.
+    Sample() *Vec3
+    // Whether light passes through the aperture at p.
+    Contains(p *Vec3) bool
+}
+
+// CircularAperture is the unit disk, a lens wide open.
+type CircularAperture struct{}
+
+func (a *CircularAperture) Sample() *Vec3 {
+    return RandomInUnitDisk()
+}
+
+func (a *CircularAperture) Contains(p *Vec3) bool {
+    return p.X * p.X + p.Y * p.Y < 1
+}
+
+// PolygonAperture is a stopped down lens whose diaphragm has straight
+// blades, a regular polygon inscribed in the unit circle with a corner at
+// Rotation degrees from the x axis.
+type PolygonAperture struct {
+    Blades int
+    Rotation float64
+}
+
+func (a *PolygonAperture) corner(k int) Vec3 {
+    angle := DegToRad(a.Rotation) + 2 * math.Pi * float64(k) / float64(a.Blades)
+    sin, cos := math.Sincos(angle)
+    return Vec3{cos, sin, 0}
+}
+
+func (a *PolygonAperture) Sample() *Vec3 {
+    if a.Blades < 3 {
+        return RandomInUnitDisk()
+    }
+
+    // Uniformly in one of the equal triangles between the center and an edge.
+    k := int(Rand() * float64(a.Blades))
+    b := a.corner(k)
+    c := a.corner(k + 1)
+    u, v := Rand(), Rand()
+    if u + v > 1 {
+        u, v = 1 - u, 1 - v
+    }
+    return b.Scale(u).Add(c.Scale(v))
+}
+
+func (a *PolygonAperture) Contains(p *Vec3) bool {
+    if a.Blades < 3 {
+        return p.X * p.X + p.Y * p.Y < 1
+    }
+
+    // Distance along the normal of the edge of the sector p is in.
+    sector := 2 * math.Pi / float64(a.Blades)
+    angle := math.Atan2(p.Y, p.X) - DegToRad(a.Rotation)
+    angle -= sector * math.Floor(angle / sector)
+    return math.Hypot(p.X, p.Y) * math.Cos(angle - sector / 2) < math.Cos(sector / 2)
+}
+
+// ImageAperture is an aperture mask painted in an image covering the square
+// around the unit disk, light passing in proportion to the luminance of its
+// pixels within the disk. Masks shaped like hearts or stars give bokeh of
+// that shape.
+type ImageAperture struct {
+    mask *imageLevel
+    maxLuminance float64
+    distribution *distribution2D
+}
+
+func MakeImageAperture(texture *ImageTexture) (*ImageAperture, error) {
+    img := &texture.image
+    fn := make([]float64, img.width * img.height)
+    a := &ImageAperture{mask: img}
+    for i := range fn {
+        // Pixels centered outside of the disk are closed.
+        x := 2 * (float64(i % img.width) + 0.5) / float64(img.width) - 1
+        y := 1 - 2 * (float64(i / img.width) + 0.5) / float64(img.height)
+        if x * x + y * y >= 1 {
+            continue
+        }
+        fn[i] = math.Max(img.pixels[i].Luminance(), 0)
+        a.maxLuminance = math.Max(a.maxLuminance, fn[i])
+    }
+    if a.maxLuminance <= 0 {
+        return nil, errors.New("aperture mask is black within the unit disk")
+    }
+    a.distribution = makeDistribution2D(fn, img.width, img.height)
+    return a, nil
+}
+
+func (a *ImageAperture) Sample() *Vec3 {
+    for {
+        x, y, _ := a.distribution.sample(Rand(), Rand())
+        p := Vec3{2 * x - 1, 1 - 2 * y, 0}
+        if p.X * p.X + p.Y * p.Y < 1 {
+            return &p
+        }
+    }
+}
+
+// Light passes a pixel of the mask with the probability of its luminance
+// relative to the brightest one.
+func (a *ImageAperture) Contains(p *Vec3) bool {
+    if a.maxLuminance <= 0 || p.X * p.X + p.Y * p.Y >= 1 {
+        return false
+    }
+    i := int(0.5 * (p.X + 1) * float64(a.mask.width))
+    j := int(0.5 * (1 - p.Y) * float64(a.mask.height))
+    return Rand() * a.maxLuminance < a.mask.pixels[j * a.mask.width + i].Luminance()
+}
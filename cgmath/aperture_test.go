@@ -0,0 +1,65 @@
+package cgmath
+
+import (
+    "testing"
+)
+
+func TestApertureSampleInside(t *testing.T) {
+    // A white mask filling its whole square, corners included.
+    pixels := make([]Color, 8 * 8)
+    for i := range pixels {
+        pixels[i] = Color{1, 1, 1}
+    }
+    mask, err := MakeImageTextureFromPixels(pixels, 8, 8, LinearEncoding)
+    if err != nil {
+        t.Fatal(err)
+    }
+    image, err := MakeImageAperture(mask)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        aperture Aperture
+    }{
+        {"circular", &CircularAperture{}},
+        {"hexagon", &PolygonAperture{Blades: 6, Rotation: 15}},
+        {"image", image},
+    }
+    for _, tt := range tests {
+        for i := 0; i < 10000; i++ {
+            p := tt.aperture.Sample()
+            if p.X * p.X + p.Y * p.Y >= 1 {
+                t.Fatalf("%s: sample %v outside of the unit disk", tt.name, *p)
+            }
+            if !tt.aperture.Contains(p) {
+                t.Fatalf("%s: sample %v not contained", tt.name, *p)
+            }
+        }
+    }
+}
+
+func TestImageApertureOutsideDisk(t *testing.T) {
+    pixels := make([]Color, 8 * 8)
+    for i := range pixels {
+        pixels[i] = Color{1, 1, 1}
+    }
+    mask, _ := MakeImageTextureFromPixels(pixels, 8, 8, LinearEncoding)
+    a, err := MakeImageAperture(mask)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if a.Contains(&Vec3{0.9, 0.9, 0}) {
+        t.Error("corner of the mask outside of the disk lets light through")
+    }
+
+    // Light only in the corners, outside of the disk.
+    corners := make([]Color, 8 * 8)
+    corners[0] = Color{1, 1, 1}
+    corners[63] = Color{1, 1, 1}
+    mask, _ = MakeImageTextureFromPixels(corners, 8, 8, LinearEncoding)
+    if _, err := MakeImageAperture(mask); err == nil {
+        t.Error("mask black within the disk accepted")
+    }
+}
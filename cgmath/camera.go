@@ -4,51 +4,274 @@ import (
     "math"
 )
 
-type Camera struct {
-    origin Vec3
-    lowerLeftCorner Vec3
-    horizontal Vec3
-    vertical Vec3
-    u, v, w Vec3
-    lensRadius float64
-    time0, time1 float64
+// Camera makes the rays of an image, for image coordinates (u, v) running
+// from (0, 0) at the lower left to (1, 1) at the upper right corner.
+type Camera interface {
+    // Make a ray through (u, v), false when the point is outside of what the
+    // camera sees, like the corners of a circular fisheye image.
+    MakeRay(u, v float64) (Ray, bool)
+    // Set the resolution of the image, with (u, v) = (i / (width - 1), j /
+    // (height - 1)) for pixel (i, j), so rays carry differentials to the
+    // neighboring pixels.
+    SetImageSize(width int, height int)
 }
 
-func MakeCamera(lookFrom *Vec3, lookAt *Vec3, vUp *Vec3, vFov float64, aspectRatio float64, aperture float64, focusDist float64,
-    time0 float64, time1 float64) Camera {
-    cam := Camera{}
-
-    theta := DegToRad(vFov)
-    h := math.Tan(theta / 2.0)
-    viewportHeight := 2.0 * h
-    viewportWidth := aspectRatio * viewportHeight
+// CameraTransform places a camera in the world. In camera space x points
+// right, y up and the camera looks down -z; U, V and W are those axes in the
+// world.
+type CameraTransform struct {
+    Origin Vec3
+    U, V, W Vec3
+}
 
+// Transform of a camera at lookFrom looking towards lookAt, with vUp
+// pointing up in the image.
+func MakeLookAt(lookFrom *Vec3, lookAt *Vec3, vUp *Vec3) CameraTransform {
     w := lookFrom.Sub(lookAt).UnitVector()
     u := vUp.Cross(w).UnitVector()
     v := w.Cross(u)
+    return CameraTransform{Origin: *lookFrom, U: *u, V: *v, W: *w}
+}
+
+// Transform from a rotation whose columns are the camera axes in the world.
+func MakeCameraTransform(rotation *Mat3, origin *Vec3) CameraTransform {
+    column := func(i int) Vec3 {
+        return Vec3{rotation[0][i], rotation[1][i], rotation[2][i]}
+    }
+    return CameraTransform{Origin: *origin, U: column(0), V: column(1), W: column(2)}
+}
+
+func (t *CameraTransform) direction(d *Vec3) *Vec3 {
+    return t.U.Scale(d.X).Add(t.V.Scale(d.Y)).Add(t.W.Scale(d.Z))
+}
+
+func (t *CameraTransform) point(p *Vec3) *Vec3 {
+    return t.Origin.Add(t.direction(p))
+}
+
+// State shared by all cameras.
+type cameraBase struct {
+    Transform CameraTransform
+    // Interval over which ray times are spread, [0, 1] by default.
+    ShutterOpen, ShutterClose float64
+    // How ray times are spread over the interval, evenly when nil.
+    Shutter ShutterCurve
+    // Fraction of the interval spent reading out the rows one by one.
+    RollingShutter float64
+    // Shape of the lens aperture of cameras with a lens, a disk when nil.
+    Aperture Aperture
+    // Pixel spacing for differentials.
+    du, dv float64
+}
+
+// Camera at transform with the shutter open over [0, 1].
+func makeCameraBase(transform CameraTransform) cameraBase {
+    return cameraBase{Transform: transform, ShutterClose: 1}
+}
+
+func (c *cameraBase) SetImageSize(width int, height int) {
+    c.du = 1.0 / float64(width - 1)
+    c.dv = 1.0 / float64(height - 1)
+}
+
+func (c *cameraBase) sampleAperture() *Vec3 {
+    if c.Aperture == nil {
+        return RandomInUnitDisk()
+    }
+    return c.Aperture.Sample()
+}
+
+// A projection maps image coordinates and a point on the unit lens disk to a
+// ray origin and direction in camera space, false outside of its image.
+type projection func(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool)
+
+// Make a world space ray through (u, v) with the projection, with
+// differentials from the same lens point through the neighboring pixels.
+func (c *cameraBase) makeRay(project projection, u float64, v float64) (Ray, bool) {
+    return c.makeRayWithLens(project, c.sampleAperture(), u, v)
+}
+
+func (c *cameraBase) makeRayWithLens(project projection, lens *Vec3, u float64, v float64) (Ray, bool) {
+    orig, dir, ok := project(u, v, lens)
+    if !ok {
+        return Ray{}, false
+    }
+
+    r := Ray{
+        Orig: *c.Transform.point(&orig),
+        Dir: *c.Transform.direction(&dir),
+        Time: c.sampleTime(v),
+    }
+    if c.du > 0 && c.dv > 0 {
+        xOrig, xDir, xOk := project(u + c.du, v, lens)
+        yOrig, yDir, yOk := project(u, v + c.dv, lens)
+        if xOk && yOk {
+            r.HasDifferentials = true
+            r.RxOrig = *c.Transform.point(&xOrig)
+            r.RxDir = *c.Transform.direction(&xDir)
+            r.RyOrig = *c.Transform.point(&yOrig)
+            r.RyDir = *c.Transform.direction(&yDir)
+        }
+    }
+    return r, true
+}
+
+// PerspectiveCamera is a pinhole or, with an aperture, thin lens camera.
+// CatsEye clips the aperture towards the corners of the image by the lens
+// barrel, as a unit disk moved away from the image center by CatsEye times
+// the distance to it, one at the corners. Blocked rays are dropped, so the
+// corners darken and their bokeh turns into cat's eyes.
+type PerspectiveCamera struct {
+    cameraBase
+    CatsEye float64
+    viewportWidth, viewportHeight float64
+    lensRadius float64
+    focusDist float64
+    // Offset of the image center on the plane at distance one, for the
+    // off-axis frustums of stereo pairs.
+    shiftX float64
+}
+
+// Perspective camera with a vertical field of view in degrees, focused at
+// focusDist.
+func MakePerspectiveCamera(transform CameraTransform, vFov float64, aspectRatio float64, aperture float64, focusDist float64) *PerspectiveCamera {
+    h := math.Tan(DegToRad(vFov) / 2.0)
+    return &PerspectiveCamera{
+        cameraBase: makeCameraBase(transform),
+        viewportWidth: aspectRatio * 2.0 * h,
+        viewportHeight: 2.0 * h,
+        lensRadius: 0.5 * aperture,
+        focusDist: focusDist,
+    }
+}
 
-    cam.origin = *lookFrom
-    cam.horizontal = *(u.Scale(focusDist * viewportWidth))
-    cam.vertical = *(v.Scale(focusDist * viewportHeight))
-    cam.lowerLeftCorner = *(cam.origin.Sub(cam.horizontal.Div(2.0)).Sub(cam.vertical.Div(2.0)).Sub(w.Scale(focusDist)))
-    cam.lensRadius = 0.5 * aperture
-    cam.u = *u
-    cam.v = *v
-    cam.w = *w
+func (c *PerspectiveCamera) project(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool) {
+    if c.CatsEye > 0 && c.lensRadius > 0 {
+        // Image position with the corners at distance one from the center.
+        x, y := (u - 0.5) * math.Sqrt2, (v - 0.5) * math.Sqrt2
+        dx, dy := lens.X + c.CatsEye * x, lens.Y + c.CatsEye * y
+        if dx * dx + dy * dy >= 1 {
+            return Vec3{}, Vec3{}, false
+        }
+    }
+
+    orig := *lens.Scale(c.lensRadius)
+    focus := Vec3{
+        ((u - 0.5) * c.viewportWidth + c.shiftX) * c.focusDist,
+        (v - 0.5) * c.viewportHeight * c.focusDist,
+        -c.focusDist,
+    }
+    return orig, *focus.Sub(&orig), true
+}
+
+func (c *PerspectiveCamera) MakeRay(u, v float64) (Ray, bool) {
+    return c.makeRay(c.project, u, v)
+}
+
+// OrthographicCamera sends parallel rays from a rectangle Width wide, for
+// technical drawings and architectural elevations without perspective.
+type OrthographicCamera struct {
+    cameraBase
+    Width, Height float64
+}
+
+func MakeOrthographicCamera(transform CameraTransform, width float64, aspectRatio float64) *OrthographicCamera {
+    return &OrthographicCamera{
+        cameraBase: makeCameraBase(transform),
+        Width: width,
+        Height: width / aspectRatio,
+    }
+}
 
-    cam.time0 = time0
-    cam.time1 = time1
+func (c *OrthographicCamera) project(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool) {
+    return Vec3{(u - 0.5) * c.Width, (v - 0.5) * c.Height, 0}, Vec3{0, 0, -1}, true
+}
 
-    return cam
+func (c *OrthographicCamera) MakeRay(u, v float64) (Ray, bool) {
+    return c.makeRay(c.project, u, v)
 }
 
-func (c *Camera) MakeRay(u, v float64) Ray {
-    rd := RandomInUnitDisk().Scale(c.lensRadius)
-    offset := c.u.Scale(rd.X).Add(c.v.Scale(rd.Y))
+// FisheyeCamera is an equidistant fisheye: the angle from the view direction
+// grows linearly with the distance from the image center, up to half of Fov
+// degrees on the circle inscribed in the image.
+type FisheyeCamera struct {
+    cameraBase
+    Fov float64
+    aspectRatio float64
+}
 
-    return Ray{
-        Orig: *c.origin.Add(offset),
-        Dir: *(c.lowerLeftCorner.Add(c.horizontal.Scale(u)).Add(c.vertical.Scale(v)).Sub(&c.origin).Sub(offset)),
-        Time: RandInRange(c.time0, c.time1),
+func MakeFisheyeCamera(transform CameraTransform, fov float64, aspectRatio float64) *FisheyeCamera {
+    return &FisheyeCamera{cameraBase: makeCameraBase(transform), Fov: fov, aspectRatio: aspectRatio}
+}
+
+func (c *FisheyeCamera) project(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool) {
+    x := 2 * (u - 0.5)
+    y := 2 * (v - 0.5)
+    if c.aspectRatio >= 1 {
+        x *= c.aspectRatio
+    } else {
+        y /= c.aspectRatio
+    }
+    r := math.Sqrt(x * x + y * y)
+    if r > 1 {
+        return Vec3{}, Vec3{}, false
     }
+
+    theta := r * DegToRad(c.Fov) / 2
+    phi := math.Atan2(y, x)
+    sinTheta := math.Sin(theta)
+    return Vec3{}, Vec3{sinTheta * math.Cos(phi), sinTheta * math.Sin(phi), -math.Cos(theta)}, true
+}
+
+func (c *FisheyeCamera) MakeRay(u, v float64) (Ray, bool) {
+    return c.makeRay(c.project, u, v)
+}
+
+// EquirectangularCamera sees the whole sphere around it, longitude along u
+// from behind through the view direction at the center, latitude along v.
+// Images should be twice as wide as they are high. A non-zero EyeOffset
+// moves the ray origins sideways, perpendicular to each column's view
+// direction, for one eye of an omni-directional stereo pair.
+type EquirectangularCamera struct {
+    cameraBase
+    EyeOffset float64
+}
+
+func MakeEquirectangularCamera(transform CameraTransform) *EquirectangularCamera {
+    return &EquirectangularCamera{cameraBase: makeCameraBase(transform)}
+}
+
+func (c *EquirectangularCamera) project(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool) {
+    phi := 2 * math.Pi * (u - 0.5)
+    theta := math.Pi * (v - 0.5)
+    cosTheta := math.Cos(theta)
+    sinPhi, cosPhi := math.Sincos(phi)
+    orig := Vec3{c.EyeOffset * cosPhi, 0, c.EyeOffset * sinPhi}
+    return orig, Vec3{cosTheta * sinPhi, math.Sin(theta), -cosTheta * cosPhi}, true
+}
+
+func (c *EquirectangularCamera) MakeRay(u, v float64) (Ray, bool) {
+    return c.makeRay(c.project, u, v)
+}
+
+// CylindricalCamera is a panorama HFov degrees around, projected onto a
+// cylinder so that vertical lines stay straight, with a vertical field of
+// view of VFov degrees.
+type CylindricalCamera struct {
+    cameraBase
+    HFov, VFov float64
+}
+
+func MakeCylindricalCamera(transform CameraTransform, hFov float64, vFov float64) *CylindricalCamera {
+    return &CylindricalCamera{cameraBase: makeCameraBase(transform), HFov: hFov, VFov: vFov}
+}
+
+func (c *CylindricalCamera) project(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool) {
+    phi := DegToRad(c.HFov) * (u - 0.5)
+    y := 2 * math.Tan(DegToRad(c.VFov) / 2) * (v - 0.5)
+    return Vec3{}, Vec3{math.Sin(phi), y, -math.Cos(phi)}, true
+}
+
+func (c *CylindricalCamera) MakeRay(u, v float64) (Ray, bool) {
+    return c.makeRay(c.project, u, v)
 }
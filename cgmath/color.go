@@ -1,11 +1,5 @@
 package cgmath
 
-import (
-    "fmt"
-    "io"
-    "math"
-)
-
 type Color struct {
     R, G, B float64
 }
@@ -30,22 +24,13 @@ func (c *Color) Mul(d *Color) *Color {
     return &Color{c.R * d.R, c.G * d.G, c.B * d.B}
 }
 
+// Relative luminance of a color in the working space.
+func (c *Color) Luminance() float64 {
+    return WorkingSpace.Luminance(c)
+}
+
 func (c *Color) Accumulate(d *Color) {
     c.R += d.R
     c.G += d.G
     c.B += d.B
 }
-
-func WriteColor(w io.Writer, c *Color, samplesPerPixel int) {
-    // Divide the color by the number of samples and gamma-correct for gamma=2.0
-    scale := 1.0 / float64(samplesPerPixel)
-    r := math.Sqrt(c.R * scale)
-    g := math.Sqrt(c.G * scale)
-    b := math.Sqrt(c.B * scale)
-
-
-    fmt.Fprintf(w, "%d %d %d\n", 
-        int(256 * Clamp(r, 0.0, 0.999)),
-        int(256 * Clamp(g, 0.0, 0.999)),
-        int(256 * Clamp(b, 0.0, 0.999)))
-}
@@ -0,0 +1,165 @@
+package cgmath
+
+import (
+    "math"
+)
+
+type Mat3 [3][3]float64
+
+func (m *Mat3) MulColor(c *Color) Color {
+    return Color{
+        R: m[0][0] * c.R + m[0][1] * c.G + m[0][2] * c.B,
+        G: m[1][0] * c.R + m[1][1] * c.G + m[1][2] * c.B,
+        B: m[2][0] * c.R + m[2][1] * c.G + m[2][2] * c.B,
+    }
+}
+
+func (m *Mat3) Mul(n *Mat3) Mat3 {
+    var r Mat3
+    for i := 0; i < 3; i++ {
+        for j := 0; j < 3; j++ {
+            for k := 0; k < 3; k++ {
+                r[i][j] += m[i][k] * n[k][j]
+            }
+        }
+    }
+    return r
+}
+
+func (m *Mat3) Inverse() Mat3 {
+    det := m[0][0] * (m[1][1] * m[2][2] - m[1][2] * m[2][1]) -
+        m[0][1] * (m[1][0] * m[2][2] - m[1][2] * m[2][0]) +
+        m[0][2] * (m[1][0] * m[2][1] - m[1][1] * m[2][0])
+    invDet := 1 / det
+    return Mat3{
+        {
+            (m[1][1] * m[2][2] - m[1][2] * m[2][1]) * invDet,
+            (m[0][2] * m[2][1] - m[0][1] * m[2][2]) * invDet,
+            (m[0][1] * m[1][2] - m[0][2] * m[1][1]) * invDet,
+        },
+        {
+            (m[1][2] * m[2][0] - m[1][0] * m[2][2]) * invDet,
+            (m[0][0] * m[2][2] - m[0][2] * m[2][0]) * invDet,
+            (m[0][2] * m[1][0] - m[0][0] * m[1][2]) * invDet,
+        },
+        {
+            (m[1][0] * m[2][1] - m[1][1] * m[2][0]) * invDet,
+            (m[0][1] * m[2][0] - m[0][0] * m[2][1]) * invDet,
+            (m[0][0] * m[1][1] - m[0][1] * m[1][0]) * invDet,
+        },
+    }
+}
+
+// ColorSpace is a linear RGB color space, given by its conversion to CIE XYZ
+// with a D65 white.
+type ColorSpace struct {
+    Name string
+    toXyz, fromXyz Mat3
+}
+
+func MakeColorSpace(name string, toXyz Mat3) *ColorSpace {
+    return &ColorSpace{Name: name, toXyz: toXyz, fromXyz: toXyz.Inverse()}
+}
+
+var srgbToXyz = Mat3{
+    {0.4124564, 0.3575761, 0.1804375},
+    {0.2126729, 0.7151522, 0.0721750},
+    {0.0193339, 0.1191920, 0.9503041},
+}
+
+// ACEScg (AP1 primaries) to linear Rec.709, Bradford adapted from the ACES
+// white point to D65.
+var acesCgToSrgb = Mat3{
+    {1.7050510, -0.6217921, -0.0832590},
+    {-0.1302564, 1.1408047, -0.0105483},
+    {-0.0240034, -0.1289690, 1.1529724},
+}
+
+var (
+    // Linear sRGB, which shares its primaries with Rec.709.
+    LinearSrgb = MakeColorSpace("Linear sRGB", srgbToXyz)
+    AcesCg = MakeColorSpace("ACEScg", srgbToXyz.Mul(&acesCgToSrgb))
+)
+
+// WorkingSpace is the color space all colors in a render are expressed in:
+// scene colors, decoded textures and the HDR image. Set it before loading
+// textures.
+var WorkingSpace = LinearSrgb
+
+func (cs *ColorSpace) ToXyz(c *Color) Color {
+    return cs.toXyz.MulColor(c)
+}
+
+func (cs *ColorSpace) FromXyz(x, y, z float64) Color {
+    return cs.fromXyz.MulColor(&Color{x, y, z})
+}
+
+// Relative luminance, the Y of the color in CIE XYZ.
+func (cs *ColorSpace) Luminance(c *Color) float64 {
+    return cs.toXyz[1][0] * c.R + cs.toXyz[1][1] * c.G + cs.toXyz[1][2] * c.B
+}
+
+// Convert a color from one linear color space to another.
+func ConvertColor(c *Color, from *ColorSpace, to *ColorSpace) Color {
+    if from == to {
+        return *c
+    }
+    xyz := from.ToXyz(c)
+    return to.FromXyz(xyz.R, xyz.G, xyz.B)
+}
+
+// The exact sRGB transfer functions, decoding (EOTF) and encoding (OETF)
+// a single channel.
+func SrgbToLinear(x float64) float64 {
+    if x <= 0.04045 {
+        return x / 12.92
+    }
+    return math.Pow((x + 0.055) / 1.055, 2.4)
+}
+
+func LinearToSrgb(x float64) float64 {
+    if x <= 0.0031308 {
+        return 12.92 * x
+    }
+    return 1.055 * math.Pow(x, 1.0 / 2.4) - 0.055
+}
+
+// How the values of an image relate to linear light. sRGB and linear
+// encoded images have sRGB primaries and are converted to the working space,
+// raw images hold data like normals or opacity that are used as is.
+type ColorEncoding int
+
+const (
+    SrgbEncoding ColorEncoding = iota
+    LinearEncoding
+    RawEncoding
+)
+
+func decode(c *Color, encoding ColorEncoding) Color {
+    if encoding == SrgbEncoding {
+        return Color{SrgbToLinear(c.R), SrgbToLinear(c.G), SrgbToLinear(c.B)}
+    }
+    return *c
+}
+
+func encode(c *Color, encoding ColorEncoding) Color {
+    if encoding == SrgbEncoding {
+        return Color{LinearToSrgb(c.R), LinearToSrgb(c.G), LinearToSrgb(c.B)}
+    }
+    return *c
+}
+
+// OutputTransform converts colors from the working space to the values
+// written to an image file.
+type OutputTransform struct {
+    Space *ColorSpace
+    Encoding ColorEncoding
+}
+
+// Standard sRGB display output.
+var SrgbOutput = OutputTransform{Space: LinearSrgb, Encoding: SrgbEncoding}
+
+func (o *OutputTransform) Apply(c *Color) Color {
+    linear := ConvertColor(c, WorkingSpace, o.Space)
+    return encode(&linear, o.Encoding)
+}
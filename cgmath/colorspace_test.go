@@ -0,0 +1,67 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+func TestSrgbTransferFunctions(t *testing.T) {
+    tests := []struct {
+        encoded float64
+        linear float64
+    }{
+        {0, 0},
+        {1, 1},
+        // Both sides of the linear segment.
+        {0.04045, 0.04045 / 12.92},
+        {0.02, 0.02 / 12.92},
+        {0.5, 0.21404114},
+        {0.735357, 0.5},
+    }
+    for _, tt := range tests {
+        if l := SrgbToLinear(tt.encoded); math.Abs(l - tt.linear) > 1e-6 {
+            t.Errorf("SrgbToLinear(%g) = %g, want %g", tt.encoded, l, tt.linear)
+        }
+        if e := LinearToSrgb(tt.linear); math.Abs(e - tt.encoded) > 1e-6 {
+            t.Errorf("LinearToSrgb(%g) = %g, want %g", tt.linear, e, tt.encoded)
+        }
+    }
+
+    // Encoding and decoding round trip over the whole range, including the
+    // 8 bit values images are stored with.
+    for i := 0; i <= 255; i++ {
+        x := float64(i) / 255
+        if y := LinearToSrgb(SrgbToLinear(x)); math.Abs(y - x) > 1e-12 {
+            t.Errorf("round trip of %g gave %g", x, y)
+        }
+        if y := SrgbToLinear(LinearToSrgb(x)); math.Abs(y - x) > 1e-12 {
+            t.Errorf("inverse round trip of %g gave %g", x, y)
+        }
+    }
+}
+
+func TestColorSpaceConversion(t *testing.T) {
+    // D65 white has the same coordinates in every space with that white
+    // point, and a luminance of one.
+    white := Color{1, 1, 1}
+    xyz := LinearSrgb.ToXyz(&white)
+    if math.Abs(xyz.R - 0.95047) > 1e-4 || math.Abs(xyz.G - 1) > 1e-4 || math.Abs(xyz.B - 1.08883) > 1e-4 {
+        t.Errorf("sRGB white in XYZ is %v, want D65", xyz)
+    }
+    if y := LinearSrgb.Luminance(&white); math.Abs(y - 1) > 1e-6 {
+        t.Errorf("luminance of white %g, want 1", y)
+    }
+
+    colors := []Color{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {0.2, 0.5, 0.8}, {4, 0.1, 0}}
+    for _, c := range colors {
+        aces := ConvertColor(&c, LinearSrgb, AcesCg)
+        back := ConvertColor(&aces, AcesCg, LinearSrgb)
+        if math.Abs(back.R - c.R) > 1e-6 || math.Abs(back.G - c.G) > 1e-6 || math.Abs(back.B - c.B) > 1e-6 {
+            t.Errorf("%v through ACEScg came back as %v", c, back)
+        }
+        // Conversion preserves luminance.
+        if y, yAces := LinearSrgb.Luminance(&c), AcesCg.Luminance(&aces); math.Abs(y - yAces) > 1e-6 {
+            t.Errorf("luminance of %v is %g in sRGB but %g in ACEScg", c, y, yAces)
+        }
+    }
+}
@@ -0,0 +1,52 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Estimate how the hit point and its UV coordinates change between
+// neighboring pixels, by intersecting the ray differentials with the tangent
+// plane at the hit. Leaves the footprint at zero when r has no differentials.
+func (h *HitRecord) ComputeDifferentials(r *Ray) {
+    h.HasDifferentials = false
+    h.Dpdx, h.Dpdy = Vec3{}, Vec3{}
+    h.Dudx, h.Dvdx, h.Dudy, h.Dvdy = 0, 0, 0, 0
+    if !r.HasDifferentials {
+        return
+    }
+
+    d := h.Normal.Dot(&h.P)
+    tx := -(h.Normal.Dot(&r.RxOrig) - d) / h.Normal.Dot(&r.RxDir)
+    ty := -(h.Normal.Dot(&r.RyOrig) - d) / h.Normal.Dot(&r.RyDir)
+    if math.IsInf(tx, 0) || math.IsNaN(tx) || math.IsInf(ty, 0) || math.IsNaN(ty) {
+        return
+    }
+    px := r.RxOrig.Add(r.RxDir.Scale(tx))
+    py := r.RyOrig.Add(r.RyDir.Scale(ty))
+    h.Dpdx = *px.Sub(&h.P)
+    h.Dpdy = *py.Sub(&h.P)
+    h.HasDifferentials = true
+
+    // Least squares fit of the offsets in terms of Dpdu and Dpdv.
+    ata00 := h.Dpdu.Dot(&h.Dpdu)
+    ata01 := h.Dpdu.Dot(&h.Dpdv)
+    ata11 := h.Dpdv.Dot(&h.Dpdv)
+    det := ata00 * ata11 - ata01 * ata01
+    if det == 0 {
+        return
+    }
+    invDet := 1 / det
+
+    uv := func(dp *Vec3) (float64, float64) {
+        atb0 := h.Dpdu.Dot(dp)
+        atb1 := h.Dpdv.Dot(dp)
+        du := (ata11 * atb0 - ata01 * atb1) * invDet
+        dv := (ata00 * atb1 - ata01 * atb0) * invDet
+        if math.IsNaN(du) || math.IsNaN(dv) {
+            return 0, 0
+        }
+        return Clamp(du, -1e8, 1e8), Clamp(dv, -1e8, 1e8)
+    }
+    h.Dudx, h.Dvdx = uv(&h.Dpdx)
+    h.Dudy, h.Dvdy = uv(&h.Dpdy)
+}
@@ -0,0 +1,92 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Wavelength dependent index of refraction, with the wavelength in
+// nanometers.
+type IorModel interface {
+    Ior(lambda float64) float64
+}
+
+// Cauchy's equation n = A + B / l^2 + C / l^4 with l in micrometers.
+type CauchyIor struct {
+    A, B, C float64
+}
+
+func (c *CauchyIor) Ior(lambda float64) float64 {
+    l2 := lambda * lambda * 1e-6
+    return c.A + c.B / l2 + c.C / (l2 * l2)
+}
+
+// Sellmeier equation n^2 = 1 + sum(B_i l^2 / (l^2 - C_i)) with l in
+// micrometers and C in square micrometers.
+type SellmeierIor struct {
+    B, C [3]float64
+}
+
+func (s *SellmeierIor) Ior(lambda float64) float64 {
+    l2 := lambda * lambda * 1e-6
+    n2 := 1.0
+    for i := 0; i < 3; i++ {
+        n2 += s.B[i] * l2 / (l2 - s.C[i])
+    }
+    return math.Sqrt(n2)
+}
+
+// Sellmeier coefficients of common optical materials.
+var (
+    Bk7 = &SellmeierIor{
+        B: [3]float64{1.03961212, 0.231792344, 1.01046945},
+        C: [3]float64{0.00600069867, 0.0200179144, 103.560653},
+    }
+    FusedSilica = &SellmeierIor{
+        B: [3]float64{0.6961663, 0.4079426, 0.8974794},
+        C: [3]float64{0.00467914826, 0.0135120631, 97.9340025},
+    }
+    Diamond = &SellmeierIor{
+        B: [3]float64{0.3306, 4.3356, 0},
+        C: [3]float64{0.030625, 0.011236, 0},
+    }
+)
+
+// DispersiveDielectric is a Dielectric whose refractive index depends on the
+// wavelength, splitting white light into its colors. The first dispersive
+// event on a path picks the hero wavelength the rest of the path is traced
+// at and weights the path to convert it back to RGB.
+type DispersiveDielectric struct {
+    Ior IorModel
+}
+
+func (mat *DispersiveDielectric) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    path := *rayIn
+    if path.Wavelength > 0 {
+        *attenuation = Color{1.0, 1.0, 1.0}
+    } else {
+        path.Wavelength = SampleWavelength()
+        weight := WavelengthToRgb(path.Wavelength)
+        *attenuation = ConvertColor(&weight, LinearSrgb, WorkingSpace)
+    }
+
+    var c Color
+    dielectric := Dielectric{RefractiveIndex: mat.Ior.Ior(path.Wavelength)}
+    return dielectric.Scatter(&path, rec, &c, scattered)
+}
+
+// Every wavelength refracts in its own direction, so only the hero wavelength
+// continues.
+func (mat *DispersiveDielectric) ScatterSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool {
+    lambdas.TerminateSecondary()
+    path := *rayIn
+    path.Wavelength = lambdas.Lambda[0]
+    *attenuation = MakeConstantSpectrum(1.0)
+
+    var c Color
+    dielectric := Dielectric{RefractiveIndex: mat.Ior.Ior(path.Wavelength)}
+    return dielectric.Scatter(&path, rec, &c, scattered)
+}
+
+func (mat *DispersiveDielectric) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    return &Color{0, 0, 0}
+}
@@ -0,0 +1,39 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+func TestIorModels(t *testing.T) {
+    tests := []struct {
+        name string
+        ior IorModel
+        lambda float64
+        want float64
+        tolerance float64
+    }{
+        // Catalog values at the Fraunhofer d, F and C lines.
+        {"BK7 d", Bk7, 587.6, 1.5168, 1e-4},
+        {"BK7 F", Bk7, 486.1, 1.5224, 1e-4},
+        {"BK7 C", Bk7, 656.3, 1.5143, 1e-4},
+        {"fused silica d", FusedSilica, 587.6, 1.4585, 1e-4},
+        {"diamond d", Diamond, 587.6, 2.417, 1e-3},
+        {"cauchy constant", &CauchyIor{A: 1.5}, 500, 1.5, 1e-12},
+        {"cauchy", &CauchyIor{A: 1.5, B: 0.01, C: 0.0001}, 500, 1.5 + 0.01 / 0.25 + 0.0001 / 0.0625, 1e-12},
+    }
+    for _, tt := range tests {
+        if n := tt.ior.Ior(tt.lambda); math.Abs(n - tt.want) > tt.tolerance {
+            t.Errorf("%s: n(%g) = %.5f, want %.5f", tt.name, tt.lambda, n, tt.want)
+        }
+    }
+}
+
+func TestIorNormalDispersion(t *testing.T) {
+    // Every material refracts blue more than red.
+    for _, ior := range []IorModel{Bk7, FusedSilica, Diamond} {
+        if ior.Ior(400) <= ior.Ior(700) {
+            t.Errorf("%v: n(400) = %g not above n(700) = %g", ior, ior.Ior(400), ior.Ior(700))
+        }
+    }
+}
@@ -0,0 +1,98 @@
+package cgmath
+
+import (
+    "sort"
+)
+
+// Piecewise constant 1D distribution over [0, 1], proportional to a tabulated
+// function, sampled by inverting its CDF.
+type distribution1D struct {
+    fn []float64
+    cdf []float64
+    integral float64
+}
+
+func makeDistribution1D(fn []float64) *distribution1D {
+    n := len(fn)
+    d := &distribution1D{fn: fn, cdf: make([]float64, n + 1)}
+    for i := 1; i <= n; i++ {
+        d.cdf[i] = d.cdf[i - 1] + fn[i - 1] / float64(n)
+    }
+    d.integral = d.cdf[n]
+    for i := 1; i <= n; i++ {
+        if d.integral == 0 {
+            d.cdf[i] = float64(i) / float64(n)
+        } else {
+            d.cdf[i] /= d.integral
+        }
+    }
+    return d
+}
+
+// Sample a point in [0, 1] from a uniform random number, returning the
+// point, its density and the index of its segment.
+func (d *distribution1D) sample(u float64) (float64, float64, int) {
+    n := len(d.fn)
+    offset := sort.Search(n + 1, func(i int) bool {
+        return d.cdf[i] > u
+    }) - 1
+    if offset < 0 {
+        offset = 0
+    }
+    if offset > n - 1 {
+        offset = n - 1
+    }
+
+    du := u - d.cdf[offset]
+    if width := d.cdf[offset + 1] - d.cdf[offset]; width > 0 {
+        du /= width
+    }
+    return (float64(offset) + du) / float64(n), d.pdf(offset), offset
+}
+
+func (d *distribution1D) pdf(offset int) float64 {
+    if d.integral == 0 {
+        return 1
+    }
+    return d.fn[offset] / d.integral
+}
+
+func (d *distribution1D) offset(x float64) int {
+    n := len(d.fn)
+    return int(Clamp(x * float64(n), 0, float64(n - 1)))
+}
+
+// Piecewise constant 2D distribution over [0, 1]², from a function tabulated
+// row by row: a marginal distribution over rows and a conditional one within
+// each row.
+type distribution2D struct {
+    conditional []*distribution1D
+    marginal *distribution1D
+}
+
+func makeDistribution2D(fn []float64, width int, height int) *distribution2D {
+    d := &distribution2D{conditional: make([]*distribution1D, height)}
+    rows := make([]float64, height)
+    for j := 0; j < height; j++ {
+        d.conditional[j] = makeDistribution1D(fn[j * width:(j + 1) * width])
+        rows[j] = d.conditional[j].integral
+    }
+    d.marginal = makeDistribution1D(rows)
+    return d
+}
+
+// Sample a point (x, y) with its density.
+func (d *distribution2D) sample(u1 float64, u2 float64) (float64, float64, float64) {
+    y, pdfY, row := d.marginal.sample(u2)
+    x, pdfX, _ := d.conditional[row].sample(u1)
+    return x, y, pdfX * pdfY
+}
+
+func (d *distribution2D) pdf(x float64, y float64) float64 {
+    row := d.marginal.offset(y)
+    conditional := d.conditional[row]
+    if d.marginal.integral == 0 {
+        return 1
+    }
+    return conditional.fn[conditional.offset(x)] / d.marginal.integral
+}
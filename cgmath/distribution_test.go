@@ -0,0 +1,105 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+func TestDistribution1D(t *testing.T) {
+    tests := []struct {
+        name string
+        fn []float64
+    }{
+        {"uniform", []float64{1, 1, 1, 1}},
+        {"ramp", []float64{1, 2, 3, 4}},
+        {"with zeros", []float64{0, 5, 0, 1}},
+        {"single", []float64{3}},
+        {"all zero", []float64{0, 0, 0}},
+    }
+    for _, tt := range tests {
+        d := makeDistribution1D(tt.fn)
+        n := len(tt.fn)
+        sum := 0.0
+        for _, f := range tt.fn {
+            sum += f
+        }
+        if want := sum / float64(n); math.Abs(d.integral - want) > 1e-12 {
+            t.Errorf("%s: integral %g, want %g", tt.name, d.integral, want)
+        }
+
+        counts := make([]int, n)
+        const samples = 1000
+        for i := 0; i < samples; i++ {
+            u := (float64(i) + 0.5) / samples
+            x, pdf, offset := d.sample(u)
+            if x < 0 || x >= 1 {
+                t.Errorf("%s: sample(%g) = %g outside [0, 1)", tt.name, u, x)
+                continue
+            }
+            if offset != d.offset(x) {
+                t.Errorf("%s: sample(%g) = %g in segment %d, offset gives %d", tt.name, u, x, offset, d.offset(x))
+            }
+            if pdf != d.pdf(offset) || pdf <= 0 {
+                t.Errorf("%s: sample(%g) has density %g, pdf gives %g", tt.name, u, pdf, d.pdf(offset))
+            }
+            counts[offset]++
+        }
+
+        // Stratified samples land in each segment in proportion to its
+        // density.
+        for i, count := range counts {
+            want := d.pdf(i) / float64(n) * samples
+            if math.Abs(float64(count) - want) > 1 {
+                t.Errorf("%s: %d samples in segment %d, want %g", tt.name, count, i, want)
+            }
+        }
+    }
+}
+
+func TestDistribution2D(t *testing.T) {
+    width, height := 3, 2
+    fn := []float64{
+        1, 0, 2,
+        0, 4, 1,
+    }
+    d := makeDistribution2D(fn, width, height)
+
+    // The density integrates to one and matches the function up to scale.
+    total := 0.0
+    for _, f := range fn {
+        total += f
+    }
+    for j := 0; j < height; j++ {
+        for i := 0; i < width; i++ {
+            x := (float64(i) + 0.5) / float64(width)
+            y := (float64(j) + 0.5) / float64(height)
+            want := fn[j * width + i] / total * float64(width * height)
+            if pdf := d.pdf(x, y); math.Abs(pdf - want) > 1e-12 {
+                t.Errorf("pdf(%g, %g) = %g, want %g", x, y, pdf, want)
+            }
+        }
+    }
+
+    const samples = 64
+    counts := make([]int, width * height)
+    for a := 0; a < samples; a++ {
+        for b := 0; b < samples; b++ {
+            u1 := (float64(a) + 0.5) / samples
+            u2 := (float64(b) + 0.5) / samples
+            x, y, pdf := d.sample(u1, u2)
+            if x < 0 || x >= 1 || y < 0 || y >= 1 {
+                t.Fatalf("sample(%g, %g) = (%g, %g) outside the unit square", u1, u2, x, y)
+            }
+            if want := d.pdf(x, y); math.Abs(pdf - want) > 1e-12 {
+                t.Errorf("sample(%g, %g) has density %g, pdf gives %g", u1, u2, pdf, want)
+            }
+            counts[int(y * float64(height)) * width + int(x * float64(width))]++
+        }
+    }
+    for i, count := range counts {
+        want := fn[i] / total * samples * samples
+        if math.Abs(float64(count) - want) > 0.02 * samples * samples {
+            t.Errorf("%d samples in cell %d, want %g", count, i, want)
+        }
+    }
+}
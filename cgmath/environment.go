@@ -0,0 +1,148 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Environment is the light arriving from infinitely far away, seen by rays
+// that leave the scene. Directions point away from the scene, towards the
+// environment.
+type Environment interface {
+    Radiance(dir *Vec3) Color
+    // Sample a unit direction towards the environment, returning it with
+    // the radiance along it and its density per solid angle.
+    Sample() (Vec3, Color, float64)
+    Pdf(dir *Vec3) float64
+}
+
+const uniformSpherePdf = 1 / (4 * math.Pi)
+
+// ConstantBackground is the same color in every direction.
+type ConstantBackground struct {
+    Color Color
+}
+
+func (b *ConstantBackground) Radiance(dir *Vec3) Color {
+    return b.Color
+}
+
+func (b *ConstantBackground) Sample() (Vec3, Color, float64) {
+    return *RandomUnitVector(), b.Color, uniformSpherePdf
+}
+
+func (b *ConstantBackground) Pdf(dir *Vec3) float64 {
+    return uniformSpherePdf
+}
+
+// GradientBackground blends vertically from Bottom, straight down, to Top,
+// straight up.
+type GradientBackground struct {
+    Bottom, Top Color
+}
+
+// The white to blue sky of the first book.
+func MakeSkyGradient() *GradientBackground {
+    return &GradientBackground{Bottom: Color{1, 1, 1}, Top: Color{0.5, 0.7, 1.0}}
+}
+
+func (b *GradientBackground) Radiance(dir *Vec3) Color {
+    t := 0.5 * (dir.UnitVector().Y + 1)
+    return *b.Bottom.Lerp(&b.Top, t)
+}
+
+func (b *GradientBackground) Sample() (Vec3, Color, float64) {
+    dir := *RandomUnitVector()
+    return dir, b.Radiance(&dir), uniformSpherePdf
+}
+
+func (b *GradientBackground) Pdf(dir *Vec3) float64 {
+    return uniformSpherePdf
+}
+
+// EnvironmentMap is an equirectangular HDR image around the scene, with up
+// along y. It is importance sampled in proportion to the luminance of its
+// pixels, weighted by the solid angle they cover.
+type EnvironmentMap struct {
+    Texture *ImageTexture
+    // Rotation around the y axis, in degrees.
+    Rotation float64
+    // Scale of the radiance of the image.
+    Intensity float64
+    distribution *distribution2D
+}
+
+func MakeEnvironmentMap(texture *ImageTexture) *EnvironmentMap {
+    texture.WrapU = RepeatWrap
+    texture.WrapV = ClampWrap
+
+    img := &texture.image
+    fn := make([]float64, img.width * img.height)
+    for j := 0; j < img.height; j++ {
+        // Rows near the poles cover less solid angle.
+        sinTheta := math.Sin(math.Pi * (float64(j) + 0.5) / float64(img.height))
+        for i := 0; i < img.width; i++ {
+            fn[j * img.width + i] = math.Max(img.pixels[j * img.width + i].Luminance(), 0) * sinTheta
+        }
+    }
+
+    return &EnvironmentMap{
+        Texture: texture,
+        Intensity: 1,
+        distribution: makeDistribution2D(fn, img.width, img.height),
+    }
+}
+
+// Unit direction of the equirectangular coordinates u and v, the inverse of
+// sphereUv.
+func equirectDirection(u float64, v float64) Vec3 {
+    theta := math.Pi * v
+    phi := 2 * math.Pi * u
+    sinTheta := math.Sin(theta)
+    return Vec3{-math.Cos(phi) * sinTheta, -math.Cos(theta), math.Sin(phi) * sinTheta}
+}
+
+// Rotate a direction around the y axis by the map rotation, or back.
+func (e *EnvironmentMap) rotate(dir *Vec3, inverse bool) Vec3 {
+    angle := DegToRad(e.Rotation)
+    if inverse {
+        angle = -angle
+    }
+    sin, cos := math.Sincos(angle)
+    return Vec3{cos * dir.X + sin * dir.Z, dir.Y, -sin * dir.X + cos * dir.Z}
+}
+
+// Image coordinates, with y down from the top row, of a world direction.
+func (e *EnvironmentMap) imageCoordinates(dir *Vec3) (float64, float64) {
+    local := e.rotate(dir.UnitVector(), true)
+    u, v := sphereUv(&local)
+    return u, 1 - v
+}
+
+func (e *EnvironmentMap) Radiance(dir *Vec3) Color {
+    x, y := e.imageCoordinates(dir)
+    c := e.Texture.Value(x, 1 - y, nil)
+    return *c.Scale(e.Intensity)
+}
+
+func (e *EnvironmentMap) Sample() (Vec3, Color, float64) {
+    x, y, mapPdf := e.distribution.sample(Rand(), Rand())
+    local := equirectDirection(x, 1 - y)
+    dir := e.rotate(&local, false)
+
+    sinTheta := math.Sin(math.Pi * y)
+    if sinTheta == 0 {
+        return dir, Color{0, 0, 0}, 0
+    }
+    // From density over the image to density per solid angle.
+    pdf := mapPdf / (2 * math.Pi * math.Pi * sinTheta)
+    return dir, e.Radiance(&dir), pdf
+}
+
+func (e *EnvironmentMap) Pdf(dir *Vec3) float64 {
+    x, y := e.imageCoordinates(dir)
+    sinTheta := math.Sin(math.Pi * y)
+    if sinTheta == 0 {
+        return 0
+    }
+    return e.distribution.pdf(x, y) / (2 * math.Pi * math.Pi * sinTheta)
+}
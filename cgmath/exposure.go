@@ -0,0 +1,74 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Sensor size of a full frame camera, in millimeters.
+const (
+    FullFrameWidth = 36.0
+    FullFrameHeight = 24.0
+)
+
+// CameraSettings describe a camera the way a photographer does. With scene
+// units of meters and radiance in cd/m², the exposure they give maps a
+// correctly exposed scene to the range of the film.
+type CameraSettings struct {
+    // Focal length and sensor size in millimeters.
+    FocalLength float64
+    SensorWidth, SensorHeight float64
+    // Ratio of the focal length to the aperture diameter.
+    FStop float64
+    // Time the shutter is open in seconds.
+    ShutterSpeed float64
+    Iso float64
+}
+
+// Settings of a full frame camera with a 50 mm lens at f/8, 1/125 s and
+// ISO 100.
+func MakeCameraSettings() CameraSettings {
+    return CameraSettings{
+        FocalLength: 50,
+        SensorWidth: FullFrameWidth,
+        SensorHeight: FullFrameHeight,
+        FStop: 8,
+        ShutterSpeed: 1.0 / 125,
+        Iso: 100,
+    }
+}
+
+// Vertical field of view in degrees.
+func (s *CameraSettings) VFov() float64 {
+    return 2 * math.Atan(s.SensorHeight / (2 * s.FocalLength)) * 180 / math.Pi
+}
+
+func (s *CameraSettings) AspectRatio() float64 {
+    return s.SensorWidth / s.SensorHeight
+}
+
+// Diameter of the aperture in meters.
+func (s *CameraSettings) ApertureDiameter() float64 {
+    return s.FocalLength / s.FStop / 1000
+}
+
+// Exposure value at ISO 100 of the settings, the stops of light they block.
+func (s *CameraSettings) Ev100() float64 {
+    return math.Log2(s.FStop * s.FStop / s.ShutterSpeed * 100 / s.Iso)
+}
+
+// Scale from scene luminance to film values, from the saturation based
+// sensitivity of ISO 12232: film value one is the luminance that just
+// saturates the sensor, with 78 / 0.65 as the ratio of it to ISO speed
+// and lens transmission.
+func (s *CameraSettings) ExposureScale() float64 {
+    return 1 / (1.2 * math.Pow(2, s.Ev100()))
+}
+
+// Perspective camera with the settings, focused at focusDist meters, with
+// the shutter open from shutterOpen for ShutterSpeed seconds.
+func MakePhysicalCamera(transform CameraTransform, settings *CameraSettings, focusDist float64, shutterOpen float64) *PerspectiveCamera {
+    c := MakePerspectiveCamera(transform, settings.VFov(), settings.AspectRatio(), settings.ApertureDiameter(), focusDist)
+    c.ShutterOpen = shutterOpen
+    c.ShutterClose = shutterOpen + settings.ShutterSpeed
+    return c
+}
@@ -0,0 +1,48 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+func TestCameraSettingsExposure(t *testing.T) {
+    tests := []struct {
+        name string
+        fStop, shutterSpeed, iso float64
+        ev100 float64
+    }{
+        {"reference", 1, 1, 100, 0},
+        {"one stop down", 1.4142135623730951, 1, 100, 1},
+        {"faster shutter", 1, 0.5, 100, 1},
+        {"faster film", 1, 1, 200, -1},
+        {"sunny sixteen", 16, 1.0 / 100, 100, math.Log2(25600)},
+        {"defaults", 8, 1.0 / 125, 100, math.Log2(8000)},
+    }
+    for _, tt := range tests {
+        s := MakeCameraSettings()
+        s.FStop, s.ShutterSpeed, s.Iso = tt.fStop, tt.shutterSpeed, tt.iso
+        if ev := s.Ev100(); math.Abs(ev - tt.ev100) > 1e-9 {
+            t.Errorf("%s: EV100 %g, want %g", tt.name, ev, tt.ev100)
+        }
+        want := 1 / (1.2 * math.Pow(2, tt.ev100))
+        if scale := s.ExposureScale(); math.Abs(scale - want) > 1e-9 * want {
+            t.Errorf("%s: exposure scale %g, want %g", tt.name, scale, want)
+        }
+    }
+}
+
+func TestMakePhysicalCamera(t *testing.T) {
+    s := MakeCameraSettings()
+    if fov := s.VFov(); math.Abs(fov - 26.9915) > 1e-3 {
+        t.Errorf("full frame 50 mm vertical field of view %g, want 26.99", fov)
+    }
+    if d := s.ApertureDiameter(); math.Abs(d - 0.00625) > 1e-12 {
+        t.Errorf("50 mm f/8 aperture %g m, want 0.00625", d)
+    }
+
+    transform := MakeLookAt(&Vec3{0, 0, 0}, &Vec3{0, 0, -1}, &Vec3{0, 1, 0})
+    c := MakePhysicalCamera(transform, &s, 10, 2)
+    if c.ShutterOpen != 2 || math.Abs(c.ShutterClose - (2 + 1.0 / 125)) > 1e-12 {
+        t.Errorf("shutter open over [%g, %g], want [2, 2.008]", c.ShutterOpen, c.ShutterClose)
+    }
+}
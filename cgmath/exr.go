@@ -0,0 +1,327 @@
+package cgmath
+
+import (
+    "bufio"
+    "bytes"
+    "compress/zlib"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+)
+
+const exrMagic = 20000630
+
+// OpenEXR compression methods that can be decoded.
+const (
+    exrNoCompression = 0
+    exrZipsCompression = 2
+    exrZipCompression = 3
+)
+
+// OpenEXR channel pixel types.
+const (
+    exrUint = 0
+    exrHalf = 1
+    exrFloat = 2
+)
+
+type exrChannel struct {
+    name string
+    pixelType int32
+    xSampling, ySampling int32
+}
+
+func (c *exrChannel) size() int {
+    if c.pixelType == exrHalf {
+        return 2
+    }
+    return 4
+}
+
+// Decode a single part scanline OpenEXR image into linear float colors,
+// from its R, G and B channels or, for greyscale images, its Y channel.
+// Only uncompressed and ZIP compressed files are supported.
+func decodeExr(r io.Reader) (imageLevel, error) {
+    data, err := io.ReadAll(bufio.NewReader(r))
+    if err != nil {
+        return imageLevel{}, err
+    }
+    in := bytes.NewReader(data)
+
+    var magic, version int32
+    binary.Read(in, binary.LittleEndian, &magic)
+    if err := binary.Read(in, binary.LittleEndian, &version); err != nil || magic != exrMagic {
+        return imageLevel{}, errors.New("not an OpenEXR image")
+    }
+    // Tiled, long name, deep and multi-part flags.
+    if version & 0x1e00 != 0 {
+        return imageLevel{}, errors.New("only single part scanline OpenEXR images are supported")
+    }
+
+    var channels []exrChannel
+    compression := -1
+    var xMin, yMin, xMax, yMax int32
+    for {
+        name, err := readExrString(in)
+        if err != nil {
+            return imageLevel{}, err
+        }
+        if name == "" {
+            break
+        }
+        if _, err := readExrString(in); err != nil {
+            return imageLevel{}, err
+        }
+        var size int32
+        if err := binary.Read(in, binary.LittleEndian, &size); err != nil {
+            return imageLevel{}, err
+        }
+        if size < 0 || int64(size) > int64(in.Len()) {
+            return imageLevel{}, fmt.Errorf("bad size %d of OpenEXR attribute %s", size, name)
+        }
+        value := make([]byte, size)
+        if _, err := io.ReadFull(in, value); err != nil {
+            return imageLevel{}, err
+        }
+
+        switch name {
+        case "channels":
+            channels, err = parseExrChannels(value)
+            if err != nil {
+                return imageLevel{}, err
+            }
+        case "compression":
+            if len(value) < 1 {
+                return imageLevel{}, errors.New("empty OpenEXR compression attribute")
+            }
+            compression = int(value[0])
+        case "dataWindow":
+            box := bytes.NewReader(value)
+            for _, v := range []*int32{&xMin, &yMin, &xMax, &yMax} {
+                if err := binary.Read(box, binary.LittleEndian, v); err != nil {
+                    return imageLevel{}, fmt.Errorf("bad OpenEXR data window: %w", err)
+                }
+            }
+        }
+    }
+
+    linesPerChunk := 1
+    switch compression {
+    case exrNoCompression, exrZipsCompression:
+    case exrZipCompression:
+        linesPerChunk = 16
+    default:
+        return imageLevel{}, fmt.Errorf("unsupported OpenEXR compression %d", compression)
+    }
+
+    width := int(int64(xMax) - int64(xMin) + 1)
+    height := int(int64(yMax) - int64(yMin) + 1)
+    if len(channels) == 0 {
+        return imageLevel{}, errors.New("OpenEXR image has no channels")
+    }
+    if err := checkImageSize(width, height); err != nil {
+        return imageLevel{}, err
+    }
+    pixelSize := 0
+    for i := range channels {
+        if channels[i].xSampling != 1 || channels[i].ySampling != 1 {
+            return imageLevel{}, errors.New("subsampled OpenEXR channels are not supported")
+        }
+        pixelSize += channels[i].size()
+    }
+    lineSize := width * pixelSize
+
+    chunks := (height + linesPerChunk - 1) / linesPerChunk
+    if int64(chunks) * 8 > int64(in.Len()) {
+        return imageLevel{}, errors.New("OpenEXR data window is larger than the file")
+    }
+    offsets := make([]uint64, chunks)
+    if err := binary.Read(in, binary.LittleEndian, offsets); err != nil {
+        return imageLevel{}, err
+    }
+
+    level := imageLevel{pixels: make([]Color, width * height), width: width, height: height}
+    rgb := [3]int{-1, -1, -1}
+    grey := -1
+    for i := range channels {
+        switch channels[i].name {
+        case "R":
+            rgb[0] = i
+        case "G":
+            rgb[1] = i
+        case "B":
+            rgb[2] = i
+        case "Y":
+            grey = i
+        }
+    }
+    if rgb[0] < 0 && rgb[1] < 0 && rgb[2] < 0 {
+        if grey < 0 {
+            return imageLevel{}, errors.New("OpenEXR image has no R, G, B or Y channel")
+        }
+        rgb = [3]int{grey, grey, grey}
+    }
+
+    for _, offset := range offsets {
+        if len(data) < 8 || offset > uint64(len(data)) - 8 {
+            return imageLevel{}, errors.New("OpenEXR chunk offset out of range")
+        }
+        y := int32(binary.LittleEndian.Uint32(data[offset:]))
+        size := uint64(binary.LittleEndian.Uint32(data[offset + 4:]))
+        if offset + 8 + size > uint64(len(data)) {
+            return imageLevel{}, errors.New("OpenEXR chunk out of range")
+        }
+        chunk := data[offset + 8:offset + 8 + size]
+
+        first := int(y - yMin)
+        lines := linesPerChunk
+        if first + lines > height {
+            lines = height - first
+        }
+        if first < 0 || lines <= 0 {
+            return imageLevel{}, errors.New("OpenEXR chunk outside of the data window")
+        }
+        expected := lines * lineSize
+        if compression != exrNoCompression && len(chunk) < expected {
+            chunk, err = inflateExrZip(chunk, expected)
+            if err != nil {
+                return imageLevel{}, err
+            }
+        }
+
+        if len(chunk) < expected {
+            return imageLevel{}, errors.New("OpenEXR chunk is too short")
+        }
+
+        for l := 0; l < lines; l++ {
+            line := chunk[l * lineSize:]
+            row := level.pixels[(first + l) * width:]
+            // Channels are stored one after the other for the whole line.
+            start := 0
+            for c := range channels {
+                ch := &channels[c]
+                for i := 0; i < width; i++ {
+                    v := readExrValue(line[start + i * ch.size():], ch.pixelType)
+                    if c == rgb[0] {
+                        row[i].R = v
+                    }
+                    if c == rgb[1] {
+                        row[i].G = v
+                    }
+                    if c == rgb[2] {
+                        row[i].B = v
+                    }
+                }
+                start += width * ch.size()
+            }
+        }
+    }
+    return level, nil
+}
+
+func readExrString(r *bytes.Reader) (string, error) {
+    var b []byte
+    for {
+        c, err := r.ReadByte()
+        if err != nil {
+            return "", err
+        }
+        if c == 0 {
+            return string(b), nil
+        }
+        b = append(b, c)
+    }
+}
+
+func parseExrChannels(value []byte) ([]exrChannel, error) {
+    r := bytes.NewReader(value)
+    var channels []exrChannel
+    for {
+        name, err := readExrString(r)
+        if err != nil {
+            return nil, err
+        }
+        if name == "" {
+            return channels, nil
+        }
+        var ch exrChannel
+        var linear [4]byte
+        ch.name = name
+        if err := binary.Read(r, binary.LittleEndian, &ch.pixelType); err != nil {
+            return nil, err
+        }
+        if _, err := io.ReadFull(r, linear[:]); err != nil {
+            return nil, err
+        }
+        if err := binary.Read(r, binary.LittleEndian, &ch.xSampling); err != nil {
+            return nil, err
+        }
+        if err := binary.Read(r, binary.LittleEndian, &ch.ySampling); err != nil {
+            return nil, err
+        }
+        channels = append(channels, ch)
+    }
+}
+
+// Undo ZIP compression: zlib, then a delta predictor over the bytes, whose
+// two halves are then interleaved.
+func inflateExrZip(chunk []byte, expected int) ([]byte, error) {
+    zr, err := zlib.NewReader(bytes.NewReader(chunk))
+    if err != nil {
+        return nil, err
+    }
+    defer zr.Close()
+    t, err := io.ReadAll(zr)
+    if err != nil {
+        return nil, err
+    }
+    if len(t) != expected {
+        return nil, errors.New("OpenEXR chunk has the wrong size")
+    }
+
+    for i := 1; i < len(t); i++ {
+        t[i] = t[i - 1] + t[i] - 128
+    }
+    out := make([]byte, len(t))
+    half := (len(t) + 1) / 2
+    for i := range out {
+        if i % 2 == 0 {
+            out[i] = t[i / 2]
+        } else {
+            out[i] = t[half + i / 2]
+        }
+    }
+    return out, nil
+}
+
+func readExrValue(b []byte, pixelType int32) float64 {
+    switch pixelType {
+    case exrHalf:
+        return halfToFloat(binary.LittleEndian.Uint16(b))
+    case exrFloat:
+        return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+    }
+    return float64(binary.LittleEndian.Uint32(b))
+}
+
+// Convert an IEEE 754 half precision float.
+func halfToFloat(h uint16) float64 {
+    sign := 1.0
+    if h & 0x8000 != 0 {
+        sign = -1.0
+    }
+    exponent := int(h >> 10) & 0x1f
+    mantissa := float64(h & 0x3ff)
+    switch exponent {
+    case 0:
+        return sign * math.Ldexp(mantissa, -24)
+    case 0x1f:
+        if mantissa == 0 {
+            return math.Inf(int(sign))
+        }
+        return math.NaN()
+    }
+    return sign * math.Ldexp(1 + mantissa / 1024, exponent - 15)
+}
@@ -0,0 +1,216 @@
+package cgmath
+
+import (
+    "bytes"
+    "compress/zlib"
+    "encoding/binary"
+    "math"
+    "testing"
+)
+
+// Half of a float that it represents exactly.
+func floatToHalf(f float64) uint16 {
+    if f == 0 {
+        return 0
+    }
+    bits := math.Float32bits(float32(f))
+    sign := uint16(bits >> 16) & 0x8000
+    exponent := uint16(int(bits >> 23 & 0xff) - 127 + 15)
+    return sign | exponent << 10 | uint16(bits >> 13 & 0x3ff)
+}
+
+// Encode an OpenEXR image whose channel values come from value, with the
+// layout decodeExr expects.
+func encodeExr(channels []exrChannel, width int, height int, compression int, value func(c int, x int, y int) float64) []byte {
+    var header bytes.Buffer
+    le := binary.LittleEndian
+    binary.Write(&header, le, int32(exrMagic))
+    binary.Write(&header, le, int32(2))
+    attribute := func(name string, kind string, value []byte) {
+        header.WriteString(name + "\x00" + kind + "\x00")
+        binary.Write(&header, le, int32(len(value)))
+        header.Write(value)
+    }
+
+    var list bytes.Buffer
+    for _, ch := range channels {
+        list.WriteString(ch.name + "\x00")
+        binary.Write(&list, le, ch.pixelType)
+        list.Write([]byte{0, 0, 0, 0})
+        binary.Write(&list, le, int32(1))
+        binary.Write(&list, le, int32(1))
+    }
+    list.WriteByte(0)
+    attribute("channels", "chlist", list.Bytes())
+    attribute("compression", "compression", []byte{byte(compression)})
+    var box bytes.Buffer
+    for _, v := range []int32{0, 0, int32(width - 1), int32(height - 1)} {
+        binary.Write(&box, le, v)
+    }
+    attribute("dataWindow", "box2i", box.Bytes())
+    header.WriteByte(0)
+
+    linesPerChunk := 1
+    if compression == exrZipCompression {
+        linesPerChunk = 16
+    }
+    var chunks [][]byte
+    for first := 0; first < height; first += linesPerChunk {
+        var raw bytes.Buffer
+        for y := first; y < first + linesPerChunk && y < height; y++ {
+            for c, ch := range channels {
+                for x := 0; x < width; x++ {
+                    v := value(c, x, y)
+                    switch ch.pixelType {
+                    case exrHalf:
+                        binary.Write(&raw, le, floatToHalf(v))
+                    case exrFloat:
+                        binary.Write(&raw, le, float32(v))
+                    default:
+                        binary.Write(&raw, le, uint32(v))
+                    }
+                }
+            }
+        }
+
+        data := raw.Bytes()
+        if compression != exrNoCompression {
+            // Split the even and odd bytes, take deltas and deflate.
+            t := make([]byte, 0, len(data))
+            for i := 0; i < len(data); i += 2 {
+                t = append(t, data[i])
+            }
+            for i := 1; i < len(data); i += 2 {
+                t = append(t, data[i])
+            }
+            for i := len(t) - 1; i > 0; i-- {
+                t[i] = t[i] - t[i - 1] + 128
+            }
+            var z bytes.Buffer
+            zw := zlib.NewWriter(&z)
+            zw.Write(t)
+            zw.Close()
+            // Chunks that do not shrink are stored uncompressed.
+            if z.Len() < len(data) {
+                data = z.Bytes()
+            }
+        }
+
+        var chunk bytes.Buffer
+        binary.Write(&chunk, le, int32(first))
+        binary.Write(&chunk, le, int32(len(data)))
+        chunk.Write(data)
+        chunks = append(chunks, chunk.Bytes())
+    }
+
+    offset := uint64(header.Len() + 8 * len(chunks))
+    for _, chunk := range chunks {
+        binary.Write(&header, le, offset)
+        offset += uint64(len(chunk))
+    }
+    for _, chunk := range chunks {
+        header.Write(chunk)
+    }
+    return header.Bytes()
+}
+
+// Values exact in half precision, different for every channel and pixel.
+func exrTestValue(c int, x int, y int) float64 {
+    return float64(c) + float64(x) / 4 + float64(y) / 32
+}
+
+func TestDecodeExr(t *testing.T) {
+    rgb := func(r int32, g int32, b int32) []exrChannel {
+        // Channels are stored in alphabetical order.
+        return []exrChannel{{name: "B", pixelType: b}, {name: "G", pixelType: g}, {name: "R", pixelType: r}}
+    }
+    tests := []struct {
+        name string
+        channels []exrChannel
+        compression int
+    }{
+        {"uncompressed half", rgb(exrHalf, exrHalf, exrHalf), exrNoCompression},
+        {"uncompressed float", rgb(exrFloat, exrFloat, exrFloat), exrNoCompression},
+        {"zips mixed", rgb(exrHalf, exrFloat, exrHalf), exrZipsCompression},
+        {"zip half", rgb(exrHalf, exrHalf, exrHalf), exrZipCompression},
+        {"zip float", rgb(exrFloat, exrFloat, exrFloat), exrZipCompression},
+        {"greyscale", []exrChannel{{name: "Y", pixelType: exrHalf}}, exrZipCompression},
+    }
+    const width, height = 5, 20
+    for _, tt := range tests {
+        data := encodeExr(tt.channels, width, height, tt.compression, exrTestValue)
+        level, err := decodeExr(bytes.NewReader(data))
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if level.width != width || level.height != height {
+            t.Errorf("%s: size %dx%d, want %dx%d", tt.name, level.width, level.height, width, height)
+            continue
+        }
+
+        for y := 0; y < height; y++ {
+            for x := 0; x < width; x++ {
+                want := Color{exrTestValue(2, x, y), exrTestValue(1, x, y), exrTestValue(0, x, y)}
+                if len(tt.channels) == 1 {
+                    v := exrTestValue(0, x, y)
+                    want = Color{v, v, v}
+                }
+                if got := level.pixels[y * width + x]; got != want {
+                    t.Fatalf("%s: pixel (%d, %d) is %v, want %v", tt.name, x, y, got, want)
+                }
+            }
+        }
+
+        // Every truncation of the file fails cleanly.
+        for n := 0; n < len(data); n++ {
+            if _, err := decodeExr(bytes.NewReader(data[:n])); err == nil {
+                t.Fatalf("%s: truncated to %d of %d bytes decoded", tt.name, n, len(data))
+            }
+        }
+    }
+}
+
+func TestDecodeExrMalformed(t *testing.T) {
+    half := []exrChannel{{name: "R", pixelType: exrHalf}}
+    offsetTable := func(data []byte) int {
+        // The offset of the only chunk of a one pixel image, followed by
+        // the chunk's line, size and half value.
+        return len(data) - 8 - 4 - 4 - 2
+    }
+    tests := []struct {
+        name string
+        data func() []byte
+    }{
+        {"no color channels", func() []byte {
+            return encodeExr([]exrChannel{{name: "A", pixelType: exrHalf}}, 1, 1, exrNoCompression, exrTestValue)
+        }},
+        {"unsupported compression", func() []byte {
+            return encodeExr(half, 1, 1, 4, exrTestValue)
+        }},
+        {"offset past the end", func() []byte {
+            data := encodeExr(half, 1, 1, exrNoCompression, exrTestValue)
+            binary.LittleEndian.PutUint64(data[offsetTable(data):], math.MaxUint64 - 3)
+            return data
+        }},
+        {"chunk size past the end", func() []byte {
+            data := encodeExr(half, 1, 1, exrNoCompression, exrTestValue)
+            binary.LittleEndian.PutUint32(data[len(data) - 2 - 4:], math.MaxUint32)
+            return data
+        }},
+        {"huge data window", func() []byte {
+            data := encodeExr(half, 1, 1, exrNoCompression, exrTestValue)
+            window := bytes.Index(data, []byte("box2i\x00")) + len("box2i\x00") + 4
+            binary.LittleEndian.PutUint32(data[window + 8:], 1 << 30)
+            return data
+        }},
+        {"not an image", func() []byte {
+            return []byte("#?RADIANCE\n")
+        }},
+    }
+    for _, tt := range tests {
+        if _, err := decodeExr(bytes.NewReader(tt.data())); err == nil {
+            t.Errorf("%s: decoded", tt.name)
+        }
+    }
+}
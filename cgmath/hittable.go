@@ -8,9 +8,33 @@ import (
 type HitRecord struct {
     P Vec3
     Normal Vec3
+    // Partial derivatives of P with respect to the U and V surface
+    // coordinates. Together with the normal they form the tangent frame.
+    Dpdu, Dpdv Vec3
+    // Change of P and of the UV coordinates from one pixel to the next, set
+    // by ComputeDifferentials for rays that carry differentials.
+    HasDifferentials bool
+    Dpdx, Dpdy Vec3
+    Dudx, Dvdx, Dudy, Dvdy float64
+    // Position, outward normal and tangents in the space of the primitive
+    // that was hit, before any Translate or RotateY, so that solid textures
+    // move with their objects.
+    ObjectP, ObjectNormal Vec3
+    ObjectDpdu, ObjectDpdv Vec3
     T, U, V float64
     FrontFace bool
     Material Material
+    // World to local transform of a Subsurface hit, for its random walk.
+    subsurface *subsurfaceFrame
+}
+
+// Record the object space position and outward normal of a hit, together
+// with the tangents, called by primitives once they set Dpdu and Dpdv.
+func (h *HitRecord) setObjectFrame(p *Vec3, outwardNormal *Vec3) {
+    h.ObjectP = *p
+    h.ObjectNormal = *outwardNormal
+    h.ObjectDpdu = h.Dpdu
+    h.ObjectDpdv = h.Dpdv
 }
 
 // Set the normal so that it always points opposite the incident ray.
@@ -60,7 +84,9 @@ func (s *Sphere) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool {
     rec.P = *r.At(rec.T)
     outwardNormal := rec.P.Sub(&s.Center).Div(s.Radius)
     rec.SetFaceNormal(r, outwardNormal)
-    rec.U, rec.V = s.getUv(outwardNormal)
+    rec.U, rec.V = sphereUv(outwardNormal)
+    rec.Dpdu, rec.Dpdv = sphereTangents(outwardNormal, s.Radius)
+    rec.setObjectFrame(&rec.P, outwardNormal)
     rec.Material = s.Material
     return true
 }
@@ -73,11 +99,16 @@ func (s *Sphere) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool
     return true
 }
 
+func (s *Sphere) Area() float64 {
+    return 4 * math.Pi * s.Radius * s.Radius
+}
+
 func (s *Sphere) String() string {
     return fmt.Sprintf("Sphere(Radius=%02f, Center=%v)", s.Radius, s.Center)
 }
 
-func (s *Sphere) getUv(p *Vec3) (float64, float64) {
+// UV coordinates of a point p on the unit sphere.
+func sphereUv(p *Vec3) (float64, float64) {
     theta := math.Acos(-p.Y)
     phi := math.Atan2(-p.Z, p.X) + math.Pi
     u := phi / (2 * math.Pi)
@@ -85,6 +116,24 @@ func (s *Sphere) getUv(p *Vec3) (float64, float64) {
     return u, v
 }
 
+// Derivatives of the sphereUv parametrization at the point p on the unit
+// sphere, scaled to a sphere of the given radius.
+func sphereTangents(p *Vec3, radius float64) (Vec3, Vec3) {
+    dpdu := Vec3{2 * math.Pi * radius * p.Z, 0, -2 * math.Pi * radius * p.X}
+
+    sinTheta := math.Sqrt(p.X * p.X + p.Z * p.Z)
+    if sinTheta < 1e-8 {
+        // At the poles u is degenerate, pick any frame around the normal.
+        return Vec3{2 * math.Pi * radius, 0, 0}, Vec3{0, 0, math.Pi * radius}
+    }
+    dpdv := Vec3{
+        -math.Pi * radius * p.Y * p.X / sinTheta,
+        math.Pi * radius * sinTheta,
+        -math.Pi * radius * p.Y * p.Z / sinTheta,
+    }
+    return dpdu, dpdv
+}
+
 type HittableList struct {
     objects []Hittable
 }
@@ -179,6 +228,10 @@ func (s *MovingSphere) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) b
     rec.P = *r.At(rec.T)
     outwardNormal := rec.P.Sub(sCenter).Div(s.Radius)
     rec.SetFaceNormal(r, outwardNormal)
+    rec.U, rec.V = sphereUv(outwardNormal)
+    rec.Dpdu, rec.Dpdv = sphereTangents(outwardNormal, s.Radius)
+    // Relative to the sphere at its starting position.
+    rec.setObjectFrame(rec.P.Sub(sCenter).Add(&s.Center0), outwardNormal)
     rec.Material = s.Material
     return true
 }
@@ -224,10 +277,13 @@ func (rect *XyRect) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool
 
     rec.U = (x - rect.X0) / (rect.X1 - rect.X0)
     rec.V = (y - rect.Y0) / (rect.Y1 - rect.Y0)
+    rec.Dpdu = Vec3{rect.X1 - rect.X0, 0, 0}
+    rec.Dpdv = Vec3{0, rect.Y1 - rect.Y0, 0}
     rec.T = t
     rec.SetFaceNormal(r, &Vec3{0, 0, 1})
     rec.Material = rect.Material
     rec.P = *r.At(t)
+    rec.setObjectFrame(&rec.P, &Vec3{0, 0, 1})
 
     return true
 }
@@ -242,6 +298,10 @@ func (r *XyRect) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool
     return true
 }
 
+func (rect *XyRect) Area() float64 {
+    return (rect.X1 - rect.X0) * (rect.Y1 - rect.Y0)
+}
+
 func (rect *XyRect) String() string {
     return fmt.Sprintf("XyRect(x=[%02f, %02f], y=[%02f, %02f], k=%02f)", rect.X0, rect.X1, rect.Y0, rect.Y1, rect.K)
 }
@@ -270,10 +330,13 @@ func (rect *XzRect) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool
 
     rec.U = (x - rect.X0) / (rect.X1 - rect.X0)
     rec.V = (z - rect.Z0) / (rect.Z1 - rect.Z0)
+    rec.Dpdu = Vec3{rect.X1 - rect.X0, 0, 0}
+    rec.Dpdv = Vec3{0, 0, rect.Z1 - rect.Z0}
     rec.T = t
     rec.SetFaceNormal(r, &Vec3{0, 1, 0})
     rec.Material = rect.Material
     rec.P = *r.At(t)
+    rec.setObjectFrame(&rec.P, &Vec3{0, 1, 0})
 
     return true
 }
@@ -286,6 +349,10 @@ func (r *XzRect) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool
     return true
 }
 
+func (rect *XzRect) Area() float64 {
+    return (rect.X1 - rect.X0) * (rect.Z1 - rect.Z0)
+}
+
 func (rect *XzRect) String() string {
     return fmt.Sprintf("XzRect(x=[%02f, %02f], z=[%02f, %02f], k=%02f)", rect.X0, rect.X1, rect.Z0, rect.Z1, rect.K)
 }
@@ -314,10 +381,13 @@ func (rect *YzRect) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool
 
     rec.U = (y - rect.Y0) / (rect.Y1 - rect.Y0)
     rec.V = (z - rect.Z0) / (rect.Z1 - rect.Z0)
+    rec.Dpdu = Vec3{0, rect.Y1 - rect.Y0, 0}
+    rec.Dpdv = Vec3{0, 0, rect.Z1 - rect.Z0}
     rec.T = t
     rec.SetFaceNormal(r, &Vec3{1, 0, 0})
     rec.Material = rect.Material
     rec.P = *r.At(t)
+    rec.setObjectFrame(&rec.P, &Vec3{1, 0, 0})
 
     return true
 }
@@ -330,6 +400,10 @@ func (r *YzRect) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool
     return true
 }
 
+func (rect *YzRect) Area() float64 {
+    return (rect.Y1 - rect.Y0) * (rect.Z1 - rect.Z0)
+}
+
 func (rect *YzRect) String() string {
     return fmt.Sprintf("YzRect(y=[%02f, %02f], z=[%02f, %02f], k=%02f)", rect.Y0, rect.Y1, rect.Z0, rect.Z1, rect.K)
 }
@@ -366,6 +440,11 @@ func (b *Box) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool {
     return true
 }
 
+func (b *Box) Area() float64 {
+    d := b.max.Sub(&b.min)
+    return 2 * (d.X * d.Y + d.X * d.Z + d.Y * d.Z)
+}
+
 func (b *Box) String() string {
     return fmt.Sprintf("Box(min=%v, z=%v)", b.min, b.max)
 }
@@ -387,6 +466,7 @@ func (t *Translate) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool
         Orig: *r.Orig.Sub(&t.displacement),
         Dir: r.Dir,
         Time: r.Time,
+        Wavelength: r.Wavelength,
     }
 
     if !t.h.Hit(moved, tMin, tMax, rec) {
@@ -395,6 +475,7 @@ func (t *Translate) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool
 
     rec.P = *rec.P.Add(&t.displacement)
     rec.SetFaceNormal(moved, &rec.Normal)
+    rec.transformSubsurface(identitySubsurfaceFrame.rows, t.displacement.Negate())
 
     return true
 }
@@ -416,6 +497,34 @@ func (t *Translate) String() string {
     return fmt.Sprintf("Translate(displacement=%v, h=%v)", t.displacement, t.h)
 }
 
+// FlipFace turns a surface around, so that its front face is the other side,
+// e.g. to make a one sided light shine the other way.
+type FlipFace struct {
+    h Hittable
+}
+
+func MakeFlipFace(h Hittable) *FlipFace {
+    return &FlipFace{h: h}
+}
+
+func (f *FlipFace) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool {
+    if !f.h.Hit(r, tMin, tMax, rec) {
+        return false
+    }
+
+    rec.FrontFace = !rec.FrontFace
+    rec.ObjectNormal = *rec.ObjectNormal.Negate()
+    return true
+}
+
+func (f *FlipFace) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool {
+    return f.h.BoundingBox(time0, time1, outputBox)
+}
+
+func (f *FlipFace) String() string {
+    return fmt.Sprintf("FlipFace(h=%v)", f.h)
+}
+
 type RotateY struct {
    h Hittable 
    sinTheta, cosTheta float64
@@ -472,7 +581,7 @@ func (r *RotateY) Hit(ray *Ray, tMin float64, tMax float64, rec *HitRecord) bool
     direction.X = r.cosTheta * ray.Dir.X - r.sinTheta * ray.Dir.Z
     direction.Z = r.sinTheta * ray.Dir.X + r.cosTheta * ray.Dir.Z
 
-    rotated := Ray{origin, direction, ray.Time}
+    rotated := ray.Spawn(&origin, &direction)
 
     if !r.h.Hit(&rotated, tMin, tMax, rec) {
         return false
@@ -488,11 +597,27 @@ func (r *RotateY) Hit(ray *Ray, tMin float64, tMax float64, rec *HitRecord) bool
     normal.Z = -r.sinTheta * rec.Normal.X + r.cosTheta * rec.Normal.Z
 
     rec.P = p
+    rec.Dpdu = *r.rotate(&rec.Dpdu)
+    rec.Dpdv = *r.rotate(&rec.Dpdv)
     rec.SetFaceNormal(&rotated, &normal)
+    rec.transformSubsurface([3]Vec3{
+        {r.cosTheta, 0, -r.sinTheta},
+        {0, 1, 0},
+        {r.sinTheta, 0, r.cosTheta},
+    }, &Vec3{})
 
     return true
 }
 
+// Rotate a direction from object space back to world space.
+func (r *RotateY) rotate(d *Vec3) *Vec3 {
+    return &Vec3{
+        r.cosTheta * d.X + r.sinTheta * d.Z,
+        d.Y,
+        -r.sinTheta * d.X + r.cosTheta * d.Z,
+    }
+}
+
 func (r *RotateY) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool {
     *outputBox = r.box
     return r.hasBox
@@ -501,3 +626,56 @@ func (r *RotateY) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) boo
 func (r *RotateY) String() string {
     return fmt.Sprintf("RotateY(h=%v)", r.h)
 }
+
+// AlphaMask cuts holes into a Hittable with an opacity texture sampled at the
+// hit's U/V. Rejected hits are skipped and the search continues behind them,
+// so the mask is respected by every ray that is traced through Hit, shadow and
+// occlusion rays included.
+type AlphaMask struct {
+    h Hittable
+    opacity Texture
+    // Hits with an opacity below the threshold are rejected. A threshold of
+    // zero treats the opacity as the probability of accepting the hit.
+    threshold float64
+}
+
+func MakeAlphaMask(h Hittable, opacity Texture, threshold float64) *AlphaMask {
+    return &AlphaMask{
+        h: h,
+        opacity: opacity,
+        threshold: threshold,
+    }
+}
+
+func (a *AlphaMask) opaque(rec *HitRecord) bool {
+    c := TextureValue(a.opacity, rec)
+    alpha := c.Luminance()
+    if a.threshold > 0 {
+        return alpha >= a.threshold
+    }
+    return alpha >= 1 || (alpha > 0 && Rand() < alpha)
+}
+
+func (a *AlphaMask) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool {
+    // Rejected hits must not leak into rec, the caller may still hold a
+    // closer hit from another object in it.
+    var tmpRecord HitRecord
+    for {
+        if !a.h.Hit(r, tMin, tMax, &tmpRecord) {
+            return false
+        }
+        if a.opaque(&tmpRecord) {
+            *rec = tmpRecord
+            return true
+        }
+        tMin = math.Nextafter(tmpRecord.T, math.Inf(1))
+    }
+}
+
+func (a *AlphaMask) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool {
+    return a.h.BoundingBox(time0, time1, outputBox)
+}
+
+func (a *AlphaMask) String() string {
+    return fmt.Sprintf("AlphaMask(threshold=%02f, h=%v)", a.threshold, a.h)
+}
@@ -0,0 +1,253 @@
+package cgmath
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// IesProfile is the goniometric distribution of a luminaire read from an
+// IES LM-63 photometric file, type C: vertical angles run from the nadir at
+// 0 degrees to straight up at 180, horizontal angles turn around the
+// vertical axis.
+type IesProfile struct {
+    vertical []float64
+    horizontal []float64
+    // Candela values, one row of vertical angles per horizontal angle.
+    candela [][]float64
+    lumens float64
+}
+
+// Load an IES LM-63 file.
+func LoadIesProfile(path string) (*IesProfile, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    p, err := ParseIesProfile(f)
+    if err != nil {
+        return nil, fmt.Errorf("parsing %s: %w", path, err)
+    }
+    return p, nil
+}
+
+func ParseIesProfile(r io.Reader) (*IesProfile, error) {
+    scanner := bufio.NewScanner(r)
+
+    // Keyword lines up to the tilt specification.
+    tilt := ""
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if strings.HasPrefix(line, "TILT=") {
+            tilt = strings.TrimPrefix(line, "TILT=")
+            break
+        }
+    }
+    if tilt == "" {
+        return nil, errors.New("missing TILT line")
+    }
+    if tilt != "NONE" && tilt != "INCLUDE" {
+        return nil, fmt.Errorf("unsupported tilt file %q", tilt)
+    }
+
+    var values []float64
+    for scanner.Scan() {
+        fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+            return r == ' ' || r == '\t' || r == ','
+        })
+        for _, field := range fields {
+            v, err := strconv.ParseFloat(field, 64)
+            if err != nil {
+                return nil, fmt.Errorf("bad number %q", field)
+            }
+            values = append(values, v)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    next := func(n int) ([]float64, error) {
+        if n < 0 {
+            return nil, errors.New("negative count in photometric data")
+        }
+        if len(values) < n {
+            return nil, errors.New("unexpected end of photometric data")
+        }
+        v := values[:n]
+        values = values[n:]
+        return v, nil
+    }
+
+    // Tilt data only matters for lamps mounted at an angle, skip it.
+    if tilt == "INCLUDE" {
+        header, err := next(2)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := next(2 * int(header[1])); err != nil {
+            return nil, err
+        }
+    }
+
+    header, err := next(13)
+    if err != nil {
+        return nil, err
+    }
+    lamps, lumensPerLamp, multiplier := header[0], header[1], header[2]
+    nVertical, nHorizontal := int(header[3]), int(header[4])
+    photometricType := int(header[5])
+    ballast := header[10]
+    if photometricType != 1 {
+        return nil, fmt.Errorf("unsupported photometric type %d, only type C is supported", photometricType)
+    }
+    if nVertical < 1 || nHorizontal < 1 {
+        return nil, errors.New("no measured angles")
+    }
+
+    p := &IesProfile{}
+    if p.vertical, err = next(nVertical); err != nil {
+        return nil, err
+    }
+    if p.horizontal, err = next(nHorizontal); err != nil {
+        return nil, err
+    }
+    if !sort.Float64sAreSorted(p.vertical) || !sort.Float64sAreSorted(p.horizontal) {
+        return nil, errors.New("angles are not in increasing order")
+    }
+    p.candela = make([][]float64, nHorizontal)
+    for h := range p.candela {
+        row, err := next(nVertical)
+        if err != nil {
+            return nil, err
+        }
+        p.candela[h] = make([]float64, nVertical)
+        for v := range row {
+            p.candela[h][v] = row[v] * multiplier * ballast
+        }
+    }
+
+    // Absolute photometry gives -1 lumens, integrate the distribution instead.
+    p.lumens = lamps * lumensPerLamp * multiplier * ballast
+    if p.lumens <= 0 {
+        p.lumens = p.integrate()
+    }
+    if p.lumens <= 0 {
+        return nil, errors.New("luminaire emits no light")
+    }
+    return p, nil
+}
+
+// Total luminous flux of the luminaire.
+func (p *IesProfile) Lumens() float64 {
+    return p.lumens
+}
+
+// Integrate the candela distribution over the sphere.
+func (p *IesProfile) integrate() float64 {
+    const n = 180
+    sum := 0.0
+    for j := 0; j < n; j++ {
+        v := 180 * (float64(j) + 0.5) / n
+        solidAngle := (2 * math.Pi / (2 * n)) * (math.Cos(DegToRad(v - 90.0 / n)) - math.Cos(DegToRad(v + 90.0 / n)))
+        for i := 0; i < 2 * n; i++ {
+            h := 360 * (float64(i) + 0.5) / (2 * n)
+            sum += p.candelaAt(v, h) * solidAngle
+        }
+    }
+    return sum
+}
+
+// Fold a horizontal angle into the range measured, using the symmetry the
+// last measured angle implies.
+func (p *IesProfile) foldHorizontal(h float64) float64 {
+    last := p.horizontal[len(p.horizontal) - 1]
+    h = math.Mod(h, 360)
+    if h < 0 {
+        h += 360
+    }
+    switch {
+    case last == 0:
+        return 0
+    case last <= 90:
+        if h > 180 {
+            h = 360 - h
+        }
+        if h > 90 {
+            h = 180 - h
+        }
+    case last <= 180:
+        if h > 180 {
+            h = 360 - h
+        }
+    }
+    return h
+}
+
+// Position of x among sorted angles, as the index of the lower one and the
+// fraction towards the next, clamped to the measured range.
+func angleInterval(angles []float64, x float64) (int, float64) {
+    n := len(angles)
+    if n == 1 || x <= angles[0] {
+        return 0, 0
+    }
+    if x >= angles[n - 1] {
+        return n - 1, 0
+    }
+    i := sort.SearchFloat64s(angles, x) - 1
+    return i, (x - angles[i]) / (angles[i + 1] - angles[i])
+}
+
+// Candela at vertical angle v and horizontal angle h in degrees, bilinearly
+// interpolated between the measured angles.
+func (p *IesProfile) candelaAt(v float64, h float64) float64 {
+    if v < p.vertical[0] || v > p.vertical[len(p.vertical) - 1] {
+        return 0
+    }
+    h = p.foldHorizontal(h)
+
+    vi, vf := angleInterval(p.vertical, v)
+    hi, hf := angleInterval(p.horizontal, h)
+    row := func(r []float64) float64 {
+        if vf == 0 {
+            return r[vi]
+        }
+        return Lerp(r[vi], r[vi + 1], vf)
+    }
+    c := row(p.candela[hi])
+    if hf > 0 {
+        c = Lerp(c, row(p.candela[hi + 1]), hf)
+    }
+    return c
+}
+
+// Candela emitted along the unit direction dir, with the luminaire's nadir
+// along the unit vector nadir and its 0 degree horizontal plane towards c0.
+func (p *IesProfile) Candela(dir *Vec3, nadir *Vec3, c0 *Vec3) float64 {
+    v := math.Acos(Clamp(dir.Dot(nadir), -1, 1)) * 180 / math.Pi
+    c90 := nadir.Cross(c0)
+    h := math.Atan2(dir.Dot(c90), dir.Dot(c0)) * 180 / math.Pi
+    return p.candelaAt(v, h)
+}
+
+// Emission along dir relative to an isotropic point source of the same
+// luminous flux.
+func (p *IesProfile) pointFactor(dir *Vec3, nadir *Vec3, c0 *Vec3) float64 {
+    return 4 * math.Pi * p.Candela(dir, nadir, c0) / p.lumens
+}
+
+// Emitted radiance along dir relative to a Lambertian emitter of the same
+// flux, for a surface facing nadir. Behind the surface it is the upper half
+// of the profile.
+func (p *IesProfile) areaFactor(dir *Vec3, nadir *Vec3, c0 *Vec3) float64 {
+    cosine := math.Max(math.Abs(dir.Dot(nadir)), 1e-3)
+    return math.Pi * p.Candela(dir, nadir, c0) / (p.lumens * cosine)
+}
@@ -0,0 +1,94 @@
+package cgmath
+
+import (
+    "math"
+    "strings"
+    "testing"
+)
+
+// Isotropic luminaire of 1000 lumens, measured from the nadir up to the
+// horizon only.
+const isotropicIes = `IESNA:LM-63-2002
+[MANUFAC] test
+TILT=NONE
+1 1000 1 3 1 1 1 0 0 0
+1 1 100
+0 45 90
+0
+79.577472 79.577472 79.577472
+`
+
+func TestParseIesProfile(t *testing.T) {
+    uniform := 1000 / (4 * math.Pi)
+    tests := []struct {
+        name string
+        data string
+        lumens float64
+        // Candela at vertical and horizontal angles in degrees.
+        v, h, candela float64
+    }{
+        {"isotropic", isotropicIes, 1000, 30, 0, uniform},
+        {"above the measured range", isotropicIes, 1000, 120, 0, 0},
+        {"multiplier and ballast", "TILT=NONE\n1 1000 2 2 1 1 1 0 0 0\n0.5 1 100\n0 180\n0\n10 20\n", 1000, 90, 45, 15},
+        {"commas and included tilt", "TILT=INCLUDE\n1\n2\n0,90\n1,1\n1 500 1 2 1 1 1 0 0 0 1 1 100\n0 180\n0\n10 30\n", 500, 45, 0, 15},
+        {"quadrant symmetry", "TILT=NONE\n1 100 1 1 2 1 1 0 0 0 1 1 100\n0\n0 90\n4 8\n", 100, 0, 270, 8},
+        {"bilateral symmetry", "TILT=NONE\n1 100 1 1 2 1 1 0 0 0 1 1 100\n0\n0 180\n4 8\n", 100, 0, 225, 7},
+        // Absolute photometry integrates the profile for the flux.
+        {"absolute", "TILT=NONE\n1 -1 1 2 1 1 1 0 0 0 1 1 100\n0 180\n0\n10 10\n", 40 * math.Pi, 60, 0, 10},
+    }
+    for _, tt := range tests {
+        p, err := ParseIesProfile(strings.NewReader(tt.data))
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if math.Abs(p.Lumens() - tt.lumens) > 1e-3 * tt.lumens {
+            t.Errorf("%s: %g lumens, want %g", tt.name, p.Lumens(), tt.lumens)
+        }
+        if c := p.candelaAt(tt.v, tt.h); math.Abs(c - tt.candela) > 1e-4 {
+            t.Errorf("%s: %g candela at (%g, %g), want %g", tt.name, c, tt.v, tt.h, tt.candela)
+        }
+    }
+}
+
+func TestParseIesProfileMalformed(t *testing.T) {
+    tests := []struct {
+        name string
+        data string
+    }{
+        {"empty", ""},
+        {"missing tilt", "IESNA:LM-63-2002\n1 1000 1 1 1 1 1 0 0 0 1 1 100\n0\n0\n1\n"},
+        {"tilt file", "TILT=lamp.tlt\n1 1000 1 1 1 1 1 0 0 0 1 1 100\n0\n0\n1\n"},
+        {"bad number", "TILT=NONE\n1 1000 1 1 1 1 1 0 0 0 1 1 x\n0\n0\n1\n"},
+        {"truncated header", "TILT=NONE\n1 1000 1 1 1\n"},
+        {"truncated candela", "TILT=NONE\n1 1000 1 2 1 1 1 0 0 0 1 1 100\n0 90\n0\n1\n"},
+        {"truncated tilt", "TILT=INCLUDE\n1\n3\n0 45\n"},
+        {"negative tilt count", "TILT=INCLUDE\n1\n-2\n1 1000 1 1 1 1 1 0 0 0 1 1 100\n0\n0\n1\n"},
+        {"type A", "TILT=NONE\n1 1000 1 1 1 3 1 0 0 0 1 1 100\n0\n0\n1\n"},
+        {"no angles", "TILT=NONE\n1 1000 1 0 1 1 1 0 0 0 1 1 100\n0\n"},
+        {"unsorted angles", "TILT=NONE\n1 1000 1 2 1 1 1 0 0 0 1 1 100\n90 0\n0\n1 1\n"},
+        {"dark", "TILT=NONE\n1 -1 1 2 1 1 1 0 0 0 1 1 100\n0 180\n0\n0 0\n"},
+    }
+    for _, tt := range tests {
+        if _, err := ParseIesProfile(strings.NewReader(tt.data)); err == nil {
+            t.Errorf("%s: no error", tt.name)
+        }
+    }
+}
+
+func TestIesProfileCandela(t *testing.T) {
+    p, err := ParseIesProfile(strings.NewReader(isotropicIes))
+    if err != nil {
+        t.Fatal(err)
+    }
+    nadir := Vec3{0, -1, 0}
+    c0 := Vec3{1, 0, 0}
+    // Below the horizon the profile is as bright as an isotropic point of
+    // the same flux, above it dark.
+    if f := p.pointFactor(&Vec3{0, -1, 0}, &nadir, &c0); math.Abs(f - 1) > 1e-6 {
+        t.Errorf("point factor towards the nadir %g, want 1", f)
+    }
+    if c := p.Candela(&Vec3{0, 1, 0}, &nadir, &c0); c != 0 {
+        t.Errorf("%g candela straight up, want 0", c)
+    }
+}
@@ -0,0 +1,307 @@
+package cgmath
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// LensElement is one spherical surface of a lens prescription, in
+// millimeters. Thickness is the distance along the axis to the next surface
+// towards the film, Ior the index of refraction of the medium behind the
+// surface, and a zero curvature radius marks the aperture stop.
+type LensElement struct {
+    CurvatureRadius float64
+    Thickness float64
+    Ior float64
+    ApertureRadius float64
+}
+
+// Load a lens prescription file.
+func LoadLensPrescription(path string) ([]LensElement, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    elements, err := ParseLensPrescription(f)
+    if err != nil {
+        return nil, fmt.Errorf("parsing %s: %w", path, err)
+    }
+    return elements, nil
+}
+
+// Parse a lens prescription, one surface per line from the front of the lens
+// to the film: curvature radius, thickness, index of refraction and aperture
+// diameter, in the layout of published lens patents. Lines starting with #
+// are comments, and an index of zero means air.
+func ParseLensPrescription(r io.Reader) ([]LensElement, error) {
+    var elements []LensElement
+    scanner := bufio.NewScanner(r)
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || strings.HasPrefix(text, "#") {
+            continue
+        }
+
+        fields := strings.Fields(text)
+        if len(fields) != 4 {
+            return nil, fmt.Errorf("line %d: expected 4 values, got %d", line, len(fields))
+        }
+        var values [4]float64
+        for i, field := range fields {
+            v, err := strconv.ParseFloat(field, 64)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: bad number %q", line, field)
+            }
+            values[i] = v
+        }
+
+        e := LensElement{
+            CurvatureRadius: values[0],
+            Thickness: values[1],
+            Ior: values[2],
+            ApertureRadius: values[3] / 2,
+        }
+        if e.Ior == 0 {
+            e.Ior = 1
+        }
+        elements = append(elements, e)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(elements) == 0 {
+        return nil, errors.New("no lens elements")
+    }
+    return elements, nil
+}
+
+// LensSystemCamera traces rays from the film through every surface of a
+// real lens, which brings its distortion, vignetting, aberrations and the
+// change of the field of view with focus. The film is centered on the origin
+// of the transform, and scene units are meters.
+type LensSystemCamera struct {
+    cameraBase
+    elements []LensElement
+    // Axial position of the vertex of every surface, with the film at 0.
+    vertexZ []float64
+    filmWidth, filmHeight float64
+    // Index of the aperture stop, -1 without one.
+    stop int
+    stopRadius float64
+}
+
+// Make a camera with the lens focused at focusDist meters from the film.
+// The film has the given diagonal in millimeters, 43.3 for full frame, and
+// a non-zero apertureDiameter in millimeters stops the lens down.
+func MakeLensSystemCamera(transform CameraTransform, elements []LensElement, filmDiagonal float64, aspectRatio float64, focusDist float64, apertureDiameter float64) (*LensSystemCamera, error) {
+    c := &LensSystemCamera{
+        cameraBase: makeCameraBase(transform),
+        elements: append([]LensElement(nil), elements...),
+        stop: -1,
+    }
+    c.filmHeight = filmDiagonal / math.Sqrt(1 + aspectRatio * aspectRatio)
+    c.filmWidth = aspectRatio * c.filmHeight
+
+    for i, e := range c.elements {
+        if e.CurvatureRadius == 0 {
+            c.stop = i
+            c.stopRadius = e.ApertureRadius
+            if apertureDiameter > 0 {
+                c.stopRadius = math.Min(apertureDiameter / 2, e.ApertureRadius)
+            }
+        }
+    }
+
+    c.placeVertices()
+    if err := c.focus(focusDist * 1000); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// Place the surfaces along the axis from their thicknesses.
+func (c *LensSystemCamera) placeVertices() {
+    c.vertexZ = make([]float64, len(c.elements))
+    pos := 0.0
+    for i := len(c.elements) - 1; i >= 0; i-- {
+        pos -= c.elements[i].Thickness
+        c.vertexZ[i] = pos
+    }
+}
+
+// Refract the unit direction d at a surface with the unit normal n facing
+// it, false on total internal reflection.
+func refractLens(d *Vec3, n *Vec3, eta float64) (*Vec3, bool) {
+    cosI := -d.Dot(n)
+    sin2T := eta * eta * math.Max(0, 1 - cosI * cosI)
+    if sin2T >= 1 {
+        return nil, false
+    }
+    cosT := math.Sqrt(1 - sin2T)
+    return d.Scale(eta).Add(n.Scale(eta * cosI - cosT)), true
+}
+
+// Trace a ray in millimeters through the lens, with the film at z = 0 and
+// the scene towards -z, from the film side or from the scene side. Returns
+// false when a surface or the aperture stop blocks it.
+func (c *LensSystemCamera) trace(r Ray, fromFilm bool) (Ray, bool) {
+    z := c.vertexZ
+    r.Dir = *r.Dir.UnitVector()
+    for k := range c.elements {
+        i := k
+        if fromFilm {
+            i = len(c.elements) - 1 - k
+        }
+        e := &c.elements[i]
+
+        var t float64
+        var n *Vec3
+        if e.CurvatureRadius == 0 {
+            if r.Dir.Z == 0 {
+                return r, false
+            }
+            t = (z[i] - r.Orig.Z) / r.Dir.Z
+        } else {
+            // Nearer or farther intersection with the sphere, depending on
+            // which way it bulges relative to the ray.
+            center := Vec3{0, 0, z[i] + e.CurvatureRadius}
+            oc := r.Orig.Sub(&center)
+            b := oc.Dot(&r.Dir)
+            disc := b * b - oc.LengthSquared() + e.CurvatureRadius * e.CurvatureRadius
+            if disc < 0 {
+                return r, false
+            }
+            root := math.Sqrt(disc)
+            if (r.Dir.Z > 0) != (e.CurvatureRadius < 0) {
+                t = -b - root
+            } else {
+                t = -b + root
+            }
+            n = r.At(t).Sub(&center).UnitVector()
+            if n.Dot(&r.Dir) > 0 {
+                n = n.Negate()
+            }
+        }
+        if t < 0 {
+            return r, false
+        }
+
+        p := r.At(t)
+        radius := e.ApertureRadius
+        if i == c.stop {
+            radius = c.stopRadius
+        }
+        if p.X * p.X + p.Y * p.Y > radius * radius {
+            return r, false
+        }
+        if i == c.stop && c.Aperture != nil && !c.Aperture.Contains(&Vec3{p.X / radius, p.Y / radius, 0}) {
+            return r, false
+        }
+        r.Orig = *p
+
+        if n != nil {
+            // Indices of the media in front of and behind the surface.
+            front := 1.0
+            if i > 0 {
+                front = c.elements[i - 1].Ior
+            }
+            etaI, etaT := e.Ior, front
+            if !fromFilm {
+                etaI, etaT = front, e.Ior
+            }
+            d, ok := refractLens(&r.Dir, n, etaI / etaT)
+            if !ok {
+                return r, false
+            }
+            r.Dir = *d
+        }
+    }
+    return r, true
+}
+
+// Principal plane and focal point of the lens on the side a ray parallel to
+// the axis leaves it, for a ray entering from the scene or the film.
+func (c *LensSystemCamera) cardinalPoints(fromFilm bool) (float64, float64, error) {
+    height := 0.001 * math.Hypot(c.filmWidth, c.filmHeight)
+    r := Ray{Orig: Vec3{height, 0, 1}, Dir: Vec3{0, 0, -1}}
+    if !fromFilm {
+        front := 0.0
+        for _, e := range c.elements {
+            front += e.Thickness
+        }
+        r = Ray{Orig: Vec3{height, 0, -front - 1}, Dir: Vec3{0, 0, 1}}
+    }
+
+    out, ok := c.trace(r, fromFilm)
+    if !ok || out.Dir.X == 0 {
+        return 0, 0, errors.New("cannot trace a ray parallel to the axis through the lens")
+    }
+    focal := out.Orig.Z - out.Orig.X / out.Dir.X * out.Dir.Z
+    principal := out.Orig.Z + (height - out.Orig.X) / out.Dir.X * out.Dir.Z
+    return principal, focal, nil
+}
+
+// Move the lens to focus at the given distance from the film in
+// millimeters, with the thick lens approximation of the system.
+func (c *LensSystemCamera) focus(dist float64) error {
+    hImage, fImage, err := c.cardinalPoints(false)
+    if err != nil {
+        return err
+    }
+    hObject, _, err := c.cardinalPoints(true)
+    if err != nil {
+        return err
+    }
+    f := fImage - hImage
+    if f <= 0 {
+        return errors.New("lens does not focus")
+    }
+
+    // Moving the lens towards the film by delta moves both principal planes,
+    // but the object and image distances from them always add up to the
+    // same total. Solving the lens equation for the image distance needs a
+    // total of at least four focal lengths.
+    total := dist + hObject - hImage
+    if total < 4 * f {
+        return fmt.Errorf("cannot focus closer than %.3g m", (4 * f - hObject + hImage) / 1000)
+    }
+    imageDist := 0.5 * (total - math.Sqrt(total * (total - 4 * f)))
+    delta := -(hImage + imageDist)
+
+    last := &c.elements[len(c.elements) - 1]
+    if last.Thickness - delta <= 0 {
+        return errors.New("focus distance puts the lens behind the film")
+    }
+    last.Thickness -= delta
+    c.placeVertices()
+    return nil
+}
+
+// The image is upside down on the film, the film point for the top of the
+// image is at the bottom. The lens sample picks the point aimed at on the
+// rear surface.
+func (c *LensSystemCamera) project(u float64, v float64, lens *Vec3) (Vec3, Vec3, bool) {
+    film := Vec3{-(u - 0.5) * c.filmWidth, -(v - 0.5) * c.filmHeight, 0}
+    rear := c.elements[len(c.elements) - 1]
+    target := Vec3{lens.X * rear.ApertureRadius, lens.Y * rear.ApertureRadius, -rear.Thickness}
+
+    out, ok := c.trace(Ray{Orig: film, Dir: *target.Sub(&film)}, true)
+    if !ok {
+        return Vec3{}, Vec3{}, false
+    }
+    return *out.Orig.Scale(0.001), out.Dir, true
+}
+
+// The aperture shape applies at the stop, the rear surface is sampled as a
+// disk.
+func (c *LensSystemCamera) MakeRay(u, v float64) (Ray, bool) {
+    return c.makeRayWithLens(c.project, RandomInUnitDisk(), u, v)
+}
@@ -0,0 +1,106 @@
+package cgmath
+
+import (
+    "math"
+    "strings"
+    "testing"
+)
+
+// A biconvex singlet of about 50 mm focal length with the stop behind it.
+const singlet = `# radius thickness ior diameter
+50     4   1.5  20
+-50    2   0    20
+0      46  0    16
+`
+
+func TestParseLensPrescription(t *testing.T) {
+    tests := []struct {
+        name string
+        input string
+        want []LensElement
+        wantErr bool
+    }{
+        {"singlet", singlet, []LensElement{
+            {CurvatureRadius: 50, Thickness: 4, Ior: 1.5, ApertureRadius: 10},
+            {CurvatureRadius: -50, Thickness: 2, Ior: 1, ApertureRadius: 10},
+            {CurvatureRadius: 0, Thickness: 46, Ior: 1, ApertureRadius: 8},
+        }, false},
+        {"blank lines", "\n  \n30 5 1.6 12\n\n", []LensElement{
+            {CurvatureRadius: 30, Thickness: 5, Ior: 1.6, ApertureRadius: 6},
+        }, false},
+        {"empty", "", nil, true},
+        {"only comments", "# nothing\n", nil, true},
+        {"missing value", "50 4 1.5\n", nil, true},
+        {"extra value", "50 4 1.5 20 1\n", nil, true},
+        {"bad number", "50 4 glass 20\n", nil, true},
+    }
+    for _, tt := range tests {
+        got, err := ParseLensPrescription(strings.NewReader(tt.input))
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+            continue
+        }
+        if len(got) != len(tt.want) {
+            t.Errorf("%s: got %d elements, want %d", tt.name, len(got), len(tt.want))
+            continue
+        }
+        for i := range got {
+            if got[i] != tt.want[i] {
+                t.Errorf("%s: element %d is %+v, want %+v", tt.name, i, got[i], tt.want[i])
+            }
+        }
+    }
+}
+
+func TestLensSystemCameraFocus(t *testing.T) {
+    elements, err := ParseLensPrescription(strings.NewReader(singlet))
+    if err != nil {
+        t.Fatal(err)
+    }
+    lookFrom, lookAt, vUp := Vec3{0, 0, 0}, Vec3{0, 0, -1}, Vec3{0, 1, 0}
+    transform := MakeLookAt(&lookFrom, &lookAt, &vUp)
+
+    tests := []struct {
+        focusDist float64
+        wantErr bool
+    }{
+        {0.5, false},
+        {2, false},
+        {100, false},
+        // Closer than four focal lengths.
+        {0.1, true},
+    }
+    for _, tt := range tests {
+        c, err := MakeLensSystemCamera(transform, elements, 43.3, 1.5, tt.focusDist, 0)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("focus at %g m: error %v, want error %v", tt.focusDist, err, tt.wantErr)
+            continue
+        }
+        if err != nil {
+            continue
+        }
+
+        // Rays from the film center through the lens meet near the axis at
+        // the focus distance.
+        var spread float64
+        passed := 0
+        for i := 0; i < 100; i++ {
+            r, ok := c.MakeRay(0.5, 0.5)
+            if !ok {
+                continue
+            }
+            passed++
+            if r.Dir.Z >= 0 {
+                t.Fatalf("focus at %g m: ray %v does not leave towards the scene", tt.focusDist, r.Dir)
+            }
+            p := r.At((-tt.focusDist - r.Orig.Z) / r.Dir.Z)
+            spread = math.Max(spread, math.Hypot(p.X, p.Y))
+        }
+        if passed == 0 {
+            t.Errorf("focus at %g m: no rays pass the lens", tt.focusDist)
+        }
+        if spread > 0.01 * tt.focusDist {
+            t.Errorf("focus at %g m: rays spread %g m at the focus distance", tt.focusDist, spread)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Light is a light source without geometry. Rays never hit it, so it only
+// contributes through sampling it directly from points in the scene.
+type Light interface {
+    // Sample light arriving at p.
+    Sample(p *Vec3) LightSample
+}
+
+// LightSample is light arriving at a point from one direction.
+type LightSample struct {
+    // Unit direction from the point towards the light.
+    Dir Vec3
+    // Distance to the light along Dir, infinite for directional lights.
+    Distance float64
+    // Radiance arriving along Dir, or for lights that are a single point or
+    // direction, the irradiance they cause on a surface facing them.
+    Radiance Color
+    // Density per solid angle of Dir, one for single point or direction
+    // lights.
+    Pdf float64
+}
+
+// PointLight shines equally in all directions from a point. Its intensity is
+// the power per solid angle, so irradiance falls off with the squared
+// distance. An optional IES profile redistributes the same power, with its
+// nadir pointing down the y axis.
+type PointLight struct {
+    Position Vec3
+    Intensity Color
+    Profile *IesProfile
+}
+
+func (l *PointLight) Sample(p *Vec3) LightSample {
+    toLight := l.Position.Sub(p)
+    distSquared := toLight.LengthSquared()
+    dist := math.Sqrt(distSquared)
+    dir := toLight.Div(dist)
+
+    scale := 1 / distSquared
+    if l.Profile != nil {
+        scale *= l.Profile.pointFactor(dir.Negate(), &Vec3{0, -1, 0}, &Vec3{1, 0, 0})
+    }
+    return LightSample{
+        Dir: *dir,
+        Distance: dist,
+        Radiance: *l.Intensity.Scale(scale),
+        Pdf: 1,
+    }
+}
+
+// SpotLight is a point light limited to a cone around Direction. Its full
+// intensity is within InnerAngle of the axis and it fades out smoothly until
+// OuterAngle, both in degrees. An optional IES profile shapes the light
+// within the cone, with its nadir along Direction.
+type SpotLight struct {
+    Position Vec3
+    Direction Vec3
+    Intensity Color
+    InnerAngle, OuterAngle float64
+    Profile *IesProfile
+}
+
+func MakeSpotLight(position Vec3, lookAt Vec3, intensity Color, innerAngle float64, outerAngle float64) *SpotLight {
+    return &SpotLight{
+        Position: position,
+        Direction: *lookAt.Sub(&position).UnitVector(),
+        Intensity: intensity,
+        InnerAngle: innerAngle,
+        OuterAngle: outerAngle,
+    }
+}
+
+// Fraction of the intensity emitted in the unit direction dir.
+func (l *SpotLight) falloff(dir *Vec3) float64 {
+    axis := l.Direction.UnitVector()
+    profile := 1.0
+    if l.Profile != nil {
+        c0, _ := orthonormalBasis(axis)
+        profile = l.Profile.pointFactor(dir, axis, c0)
+    }
+
+    cosTheta := dir.Dot(axis)
+    cosOuter := math.Cos(DegToRad(l.OuterAngle))
+    cosInner := math.Cos(DegToRad(l.InnerAngle))
+    if cosTheta >= cosInner {
+        return profile
+    }
+    if cosTheta <= cosOuter || cosInner <= cosOuter {
+        return 0
+    }
+    t := (cosTheta - cosOuter) / (cosInner - cosOuter)
+    return profile * t * t * (3 - 2 * t)
+}
+
+func (l *SpotLight) Sample(p *Vec3) LightSample {
+    toLight := l.Position.Sub(p)
+    distSquared := toLight.LengthSquared()
+    dist := math.Sqrt(distSquared)
+    dir := toLight.Div(dist)
+    return LightSample{
+        Dir: *dir,
+        Distance: dist,
+        Radiance: *l.Intensity.Scale(l.falloff(dir.Negate()) / distSquared),
+        Pdf: 1,
+    }
+}
+
+// DirectionalLight is a light infinitely far away, like the sun, causing the
+// given irradiance on surfaces facing it. Direction points towards the light.
+// With a non-zero angular diameter in degrees it is a disk in the sky, which
+// softens the shadows it casts.
+type DirectionalLight struct {
+    Direction Vec3
+    Irradiance Color
+    AngularDiameter float64
+}
+
+func (l *DirectionalLight) Sample(p *Vec3) LightSample {
+    axis := l.Direction.UnitVector()
+    if l.AngularDiameter <= 0 {
+        return LightSample{Dir: *axis, Distance: math.Inf(1), Radiance: l.Irradiance, Pdf: 1}
+    }
+
+    // Spread the irradiance as constant radiance over the disk.
+    cosMax := math.Cos(DegToRad(0.5 * l.AngularDiameter))
+    pdf := ConePdf(cosMax)
+    return LightSample{
+        Dir: *RandomInCone(axis, cosMax),
+        Distance: math.Inf(1),
+        Radiance: *l.Irradiance.Scale(pdf),
+        Pdf: pdf,
+    }
+}
@@ -6,7 +6,50 @@ import (
 
 type Material interface {
     Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool
-    Emitted(u float64, v float64, p *Vec3) *Color
+    Emitted(rayIn *Ray, rec *HitRecord) *Color
+}
+
+// BsdfMaterial is implemented by materials whose scattering can be evaluated
+// for a given direction, which lets lights be sampled directly at their
+// surfaces. Materials without it, like Metal, Dielectric and ThinFilm, are
+// only lit by the rays they scatter, which never reach point, spot and
+// directional lights.
+type BsdfMaterial interface {
+    Material
+    // The attenuation per solid angle, cosine included, of scattering into
+    // the unit direction dir, and the density of Scatter picking dir.
+    // Specular lobes, like the coat of a CoatedMaterial or a Metal in a
+    // MixMaterial, are left out of the attenuation, and the density is zero
+    // when Scatter can pick them.
+    Eval(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64)
+}
+
+// Implemented by materials built from others, which can have specular lobes
+// besides those they evaluate.
+type layeredMaterial interface {
+    evalLobes(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64, bool)
+}
+
+// Attenuation and density of the lobes of any material that can be
+// evaluated, and whether it has specular lobes as well. Materials that are
+// not a BsdfMaterial are specular.
+func evalMaterial(mat Material, rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64, bool) {
+    switch m := mat.(type) {
+    case layeredMaterial:
+        return m.evalLobes(rayIn, rec, dir)
+    case BsdfMaterial:
+        f, pdf := m.Eval(rayIn, rec, dir)
+        return f, pdf, false
+    }
+    return Color{0, 0, 0}, 0, true
+}
+
+// Eval of a layered material, without a density when it is partly specular.
+func evalLayered(f Color, pdf float64, specular bool) (Color, float64) {
+    if specular {
+        return f, 0
+    }
+    return f, pdf
 }
 
 type Lambertian struct {
@@ -18,15 +61,26 @@ func (mat *Lambertian) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, s
     if scatterDir.NearZero() {
         scatterDir = &rec.Normal
     }
-    *scattered = Ray{Orig: rec.P, Dir: *scatterDir, Time: rayIn.Time}
-    *attenuation = mat.Albedo.Value(rec.U, rec.V, &rec.P)
+    *scattered = rayIn.Spawn(&rec.P, scatterDir)
+    *attenuation = TextureValue(mat.Albedo, rec)
     return true
 }
 
-func (mat *Lambertian) Emitted(u float64, v float64, p *Vec3) *Color {
+func (mat *Lambertian) Emitted(rayIn *Ray, rec *HitRecord) *Color {
     return &Color{0, 0, 0}
 }
 
+func (mat *Lambertian) Eval(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64) {
+    cosine := rec.Normal.Dot(dir)
+    if cosine <= 0 {
+        return Color{0, 0, 0}, 0
+    }
+    // Scatter picks directions with density cos / π.
+    pdf := cosine / math.Pi
+    albedo := TextureValue(mat.Albedo, rec)
+    return *albedo.Scale(pdf), pdf
+}
+
 type Metal struct {
     Albedo Color
     Fuzz float64
@@ -35,13 +89,19 @@ type Metal struct {
 func (mat *Metal) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
     fuzz := math.Min(mat.Fuzz, 1.0)
     reflected := Reflect(rayIn.Dir.UnitVector(), &rec.Normal)
-    reflected = reflected.Add(RandomInUnitSphere().Scale(fuzz))
-    *scattered = Ray{Orig: rec.P, Dir: *reflected, Time: rayIn.Time}
+    if fuzz > 0 {
+        reflected = reflected.Add(RandomInUnitSphere().Scale(fuzz))
+        *scattered = rayIn.Spawn(&rec.P, reflected)
+    } else {
+        *scattered = rayIn.SpawnSpecular(rec, reflected, func(d *Vec3) *Vec3 {
+            return Reflect(d, &rec.Normal)
+        })
+    }
     *attenuation = mat.Albedo
     return scattered.Dir.Dot(&rec.Normal) > 0
 }
 
-func (mat *Metal) Emitted(u float64, v float64, p *Vec3) *Color {
+func (mat *Metal) Emitted(rayIn *Ray, rec *HitRecord) *Color {
     return &Color{0, 0, 0}
 }
 
@@ -62,18 +122,20 @@ func (mat *Dielectric) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, s
 
     cannotRefract := refractionRatio * sinTheta > 1.0
 
-    var direction *Vec3
+    bounce := func(d *Vec3) *Vec3 {
+        return Refract(d, &rec.Normal, refractionRatio)
+    }
     if cannotRefract || reflectance(cosTheta, refractionRatio) > Rand() {
-        direction = Reflect(unitDirection, &rec.Normal)
-    } else {
-        direction = Refract(unitDirection, &rec.Normal, refractionRatio)
+        bounce = func(d *Vec3) *Vec3 {
+            return Reflect(d, &rec.Normal)
+        }
     }
 
-    *scattered = Ray{Orig: rec.P, Dir: *direction, Time: rayIn.Time}
+    *scattered = rayIn.SpawnSpecular(rec, bounce(unitDirection), bounce)
     return true
 }
 
-func (mat *Dielectric) Emitted(u float64, v float64, p *Vec3) *Color {
+func (mat *Dielectric) Emitted(rayIn *Ray, rec *HitRecord) *Color {
     return &Color{0, 0, 0}
 }
 
@@ -85,14 +147,232 @@ func reflectance(cosine, refractiveIdx float64) float64 {
 }
 
 type DiffuseLight struct {
-    Emit Texture 
+    Emit Texture
+    // Only emit light from the front face of the surface, the side its
+    // outward normal points to. Wrap the surface in FlipFace to emit from
+    // the other side, like a ceiling light on an XzRect shining down.
+    OneSided bool
+    // Optional IES profile that redistributes the emitted light, with its
+    // nadir along the surface normal and its 0 degree plane along the u
+    // direction.
+    Profile *IesProfile
+    // Black body temperature in Kelvin and spectral scale of lights made by
+    // MakeBlackbodyLight, so spectral rendering can use Planck's law directly.
+    temperature float64
+    blackbodyScale float64
 }
 
 func (mat *DiffuseLight) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
     return false
 }
 
-func (mat *DiffuseLight) Emitted(u float64, v float64, p *Vec3) *Color {
-    c := mat.Emit.Value(u, v, p)
-    return &c
+func (mat *DiffuseLight) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    if mat.OneSided && !rec.FrontFace {
+        return &Color{0, 0, 0}
+    }
+    c := TextureValue(mat.Emit, rec)
+    return c.Scale(mat.profileFactor(rayIn, rec))
+}
+
+// Scale of the emission towards the ray by the IES profile, if any.
+func (mat *DiffuseLight) profileFactor(rayIn *Ray, rec *HitRecord) float64 {
+    if mat.Profile == nil {
+        return 1
+    }
+    // Fixed to the outward normal, so the back of a two sided light shows
+    // the back of the profile.
+    nadir := rec.outwardNormal()
+    c0, _ := orthonormalBasis(nadir)
+    if !rec.Dpdu.NearZero() {
+        c0 = rec.Dpdu.UnitVector()
+    }
+    return mat.Profile.areaFactor(rayIn.Dir.UnitVector().Negate(), nadir, c0)
+}
+
+// Light emitting the color of a black body at the given temperature in
+// Kelvin, scaled so its luminance equals intensity.
+func MakeBlackbodyLight(kelvin float64, intensity float64, oneSided bool) *DiffuseLight {
+    c := BlackbodyColor(kelvin).Scale(intensity)
+
+    // Scale the spectrum to the same luminance as the RGB color.
+    luminance := 0.0
+    for lambda := LambdaMin; lambda <= LambdaMax; lambda++ {
+        w := WavelengthToRgb(lambda)
+        luminance += Blackbody(lambda, kelvin) * LinearSrgb.Luminance(&w) / (LambdaMax - LambdaMin + 1)
+    }
+
+    return &DiffuseLight{
+        Emit: &SolidColor{color: *c},
+        OneSided: oneSided,
+        temperature: kelvin,
+        blackbodyScale: intensity / luminance,
+    }
+}
+
+func (mat *DiffuseLight) EmittedSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths) SampledSpectrum {
+    if mat.temperature == 0 {
+        return RgbUnboundedSpectrum(mat.Emitted(rayIn, rec), lambdas)
+    }
+    var s SampledSpectrum
+    if mat.OneSided && !rec.FrontFace {
+        return s
+    }
+    scale := mat.blackbodyScale * mat.profileFactor(rayIn, rec)
+    for i := range s {
+        s[i] = scale * Blackbody(lambdas.Lambda[i], mat.temperature)
+    }
+    return s
+}
+
+type PowerUnit int
+
+const (
+    Watts PowerUnit = iota
+    Lumens
+)
+
+// Luminous efficacy in lumen per watt at 555nm, the peak of the eye's
+// sensitivity.
+const maxLuminousEfficacy = 683.0
+
+// Light with the given total emitted power, spread evenly over a surface of
+// the given area, e.g. rect.Area(). The color only sets the hue, so a light
+// can change size without changing how much light it puts into the scene.
+// A black color gives a black light.
+func MakePoweredLight(color Color, power float64, unit PowerUnit, area float64, oneSided bool) *DiffuseLight {
+    if unit == Lumens {
+        power /= maxLuminousEfficacy
+    }
+
+    // A Lambertian emitter of radiance L emits pi * L * area per side.
+    sides := 2.0
+    if oneSided {
+        sides = 1.0
+    }
+    radiance := power / (sides * math.Pi * area)
+
+    luminance := color.Luminance()
+    if luminance <= 0 {
+        return &DiffuseLight{Emit: &SolidColor{}, OneSided: oneSided}
+    }
+    c := color.Scale(radiance / luminance)
+    return &DiffuseLight{
+        Emit: &SolidColor{color: *c},
+        OneSided: oneSided,
+    }
+}
+
+// MixMaterial picks one of two materials per scattering event. The weight is
+// the luminance of Amount at the hit: 0 selects A, 1 selects B.
+type MixMaterial struct {
+    A, B Material
+    Amount Texture
+}
+
+func (mat *MixMaterial) weight(rec *HitRecord) float64 {
+    w := TextureValue(mat.Amount, rec)
+    return Clamp(w.Luminance(), 0.0, 1.0)
+}
+
+func (mat *MixMaterial) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    if Rand() < mat.weight(rec) {
+        return mat.B.Scatter(rayIn, rec, attenuation, scattered)
+    }
+    return mat.A.Scatter(rayIn, rec, attenuation, scattered)
+}
+
+func (mat *MixMaterial) ScatterSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool {
+    if Rand() < mat.weight(rec) {
+        return ScatterSpectral(mat.B, rayIn, rec, lambdas, attenuation, scattered)
+    }
+    return ScatterSpectral(mat.A, rayIn, rec, lambdas, attenuation, scattered)
+}
+
+func (mat *MixMaterial) Eval(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64) {
+    return evalLayered(mat.evalLobes(rayIn, rec, dir))
+}
+
+// Both materials weighted like Scatter picks them.
+func (mat *MixMaterial) evalLobes(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64, bool) {
+    w := mat.weight(rec)
+    fA, pdfA, specularA := evalMaterial(mat.A, rayIn, rec, dir)
+    fB, pdfB, specularB := evalMaterial(mat.B, rayIn, rec, dir)
+    return *fA.Lerp(&fB, w), Lerp(pdfA, pdfB, w), (specularA && w < 1) || (specularB && w > 0)
+}
+
+func (mat *MixMaterial) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    // Emission is not sampled, so blend the expected value of both materials.
+    w := mat.weight(rec)
+    return mat.A.Emitted(rayIn, rec).Lerp(mat.B.Emitted(rayIn, rec), w)
+}
+
+// Maximum number of times a ray can bounce between the coat and the base
+// before it is considered absorbed.
+const maxCoatInternalBounces = 8
+
+// CoatedMaterial layers a smooth, clear dielectric coat on top of Base, like
+// varnish on wood or a clear coat on car paint.
+type CoatedMaterial struct {
+    Base Material
+    RefractiveIndex float64
+}
+
+func (mat *CoatedMaterial) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    unitDirection := rayIn.Dir.UnitVector()
+    cosTheta := math.Min(-unitDirection.Dot(&rec.Normal), 1.0)
+
+    // Specular reflection off the top of the coat.
+    if reflectance(cosTheta, mat.RefractiveIndex) > Rand() {
+        *scattered = rayIn.Spawn(&rec.P, Reflect(unitDirection, &rec.Normal))
+        *attenuation = Color{1.0, 1.0, 1.0}
+        return true
+    }
+
+    // Transmitted into the coat: scatter off the base, then try to leave the
+    // coat again. Light reflected back at the coat-air interface bounces off
+    // the base once more.
+    if !mat.Base.Scatter(rayIn, rec, attenuation, scattered) {
+        return false
+    }
+    for i := 0; i < maxCoatInternalBounces; i++ {
+        outDirection := scattered.Dir.UnitVector()
+        cosOut := outDirection.Dot(&rec.Normal)
+        if cosOut <= 0 {
+            return false
+        }
+        if reflectance(cosOut, mat.RefractiveIndex) <= Rand() {
+            return true
+        }
+
+        internal := rayIn.Spawn(&rec.P, Reflect(outDirection, &rec.Normal))
+        var baseAttenuation Color
+        if !mat.Base.Scatter(&internal, rec, &baseAttenuation, scattered) {
+            return false
+        }
+        *attenuation = *attenuation.Mul(&baseAttenuation)
+    }
+    return false
+}
+
+func (mat *CoatedMaterial) Eval(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64) {
+    return evalLayered(mat.evalLobes(rayIn, rec, dir))
+}
+
+// The base seen through the coat, with the transmission into and out of it.
+// The coat itself is specular, and light reflected back at it from the base
+// is left to Scatter.
+func (mat *CoatedMaterial) evalLobes(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64, bool) {
+    cosIn := math.Min(-rayIn.Dir.UnitVector().Dot(&rec.Normal), 1.0)
+    cosOut := dir.Dot(&rec.Normal)
+    if cosOut <= 0 {
+        return Color{0, 0, 0}, 0, true
+    }
+    f, pdf, _ := evalMaterial(mat.Base, rayIn, rec, dir)
+    enter := 1 - reflectance(cosIn, mat.RefractiveIndex)
+    leave := 1 - reflectance(cosOut, mat.RefractiveIndex)
+    return *f.Scale(enter * leave), enter * pdf, true
+}
+
+func (mat *CoatedMaterial) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    return mat.Base.Emitted(rayIn, rec)
 }
@@ -0,0 +1,64 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+// Average attenuation of scattering a ray arriving at angle degrees from the
+// normal, counting absorbed rays as zero: the albedo of the material.
+func estimateAlbedo(mat Material, angle float64, samples int) Color {
+    sin, cos := math.Sincos(DegToRad(angle))
+    rayIn := Ray{Orig: Vec3{-sin, cos, 0}, Dir: Vec3{sin, -cos, 0}}
+    rec := HitRecord{
+        P: Vec3{0, 0, 0},
+        Normal: Vec3{0, 1, 0},
+        FrontFace: true,
+        T: 1,
+        Material: mat,
+    }
+
+    var sum Color
+    for i := 0; i < samples; i++ {
+        var attenuation Color
+        var scattered Ray
+        if mat.Scatter(&rayIn, &rec, &attenuation, &scattered) {
+            sum = *sum.Add(&attenuation)
+        }
+    }
+    return *sum.Scale(1.0 / float64(samples))
+}
+
+func TestLayeredMaterialEnergy(t *testing.T) {
+    white := &Lambertian{Albedo: MakeSolidColor(1, 1, 1)}
+    mirror := &Metal{Albedo: Color{1, 1, 1}}
+    half := MakeSolidColor(0.5, 0.5, 0.5)
+    tests := []struct {
+        name string
+        material Material
+        // Lower bound of the albedo, materials that absorb nothing are one.
+        min float64
+    }{
+        {"white lambertian", white, 1},
+        {"mix of white and mirror", &MixMaterial{A: white, B: mirror, Amount: half}, 1},
+        {"mix of white and grey", &MixMaterial{A: white, B: &Lambertian{Albedo: half}, Amount: half}, 0.75},
+        {"coated white", &CoatedMaterial{Base: white, RefractiveIndex: 1.5}, 0.5},
+        {"coated mirror", &CoatedMaterial{Base: mirror, RefractiveIndex: 1.5}, 0.5},
+        {"double coated", &CoatedMaterial{Base: &CoatedMaterial{Base: white, RefractiveIndex: 1.5}, RefractiveIndex: 1.3}, 0.5},
+        {"mix of coated and white", &MixMaterial{A: &CoatedMaterial{Base: white, RefractiveIndex: 1.5}, B: white, Amount: half}, 0.5},
+    }
+    const samples = 20000
+    for _, tt := range tests {
+        for _, angle := range []float64{0, 45, 80} {
+            albedo := estimateAlbedo(tt.material, angle, samples)
+            for _, a := range []float64{albedo.R, albedo.G, albedo.B} {
+                // Attenuations are at most one, so the estimate is within a
+                // few percent.
+                if a > 1 + 1e-9 || a < tt.min - 0.03 {
+                    t.Errorf("%s at %g degrees: albedo %v, want in [%g, 1]", tt.name, angle, albedo, tt.min)
+                    break
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,167 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Step in UV space used for the finite differences of a bump map.
+const bumpDelta = 0.0005
+
+// The normal of the hit on the outside of the surface, regardless of which
+// side the ray came from.
+func (h *HitRecord) outwardNormal() *Vec3 {
+    if h.FrontFace {
+        return &h.Normal
+    }
+    return h.Normal.Negate()
+}
+
+// Copy of the hit record with the shading normal replaced by the given outward
+// normal, flipped to face the incident ray like the geometric normal.
+func (h *HitRecord) withShadingNormal(outward *Vec3) HitRecord {
+    shading := *h
+    n := outward.UnitVector()
+    if !h.FrontFace {
+        n = n.Negate()
+    }
+    shading.Normal = *n
+    return shading
+}
+
+// Orthonormal tangent frame (T, B, N) around the outward normal, with T
+// following Dpdu and B on the same side as Dpdv.
+func (h *HitRecord) tangentFrame() (*Vec3, *Vec3, *Vec3) {
+    n := h.outwardNormal()
+    t := h.Dpdu.Sub(n.Scale(n.Dot(&h.Dpdu)))
+    if t.NearZero() {
+        // No usable parametrization, pick any vector perpendicular to n.
+        if math.Abs(n.X) > 0.9 {
+            t = n.Cross(&Vec3{0, 1, 0})
+        } else {
+            t = n.Cross(&Vec3{1, 0, 0})
+        }
+    }
+    t = t.UnitVector()
+    b := n.Cross(t)
+    if b.Dot(&h.Dpdv) < 0 {
+        b = b.Negate()
+    }
+    return t, b, n
+}
+
+// NormalMap perturbs the shading normal of Base with a tangent-space normal
+// map, where the red, green and blue channels in [0, 1] encode the X, Y and Z
+// components of the normal in [-1, 1].
+type NormalMap struct {
+    Base Material
+    Map Texture
+    // Blend between the geometric normal (0) and the mapped normal (1).
+    Strength float64
+}
+
+func MakeNormalMap(base Material, normalMap Texture) *NormalMap {
+    return &NormalMap{Base: base, Map: normalMap, Strength: 1.0}
+}
+
+func (mat *NormalMap) shade(rec *HitRecord) HitRecord {
+    c := TextureValue(mat.Map, rec)
+    x := (2 * c.R - 1) * mat.Strength
+    y := (2 * c.G - 1) * mat.Strength
+    z := Lerp(1.0, 2 * c.B - 1, mat.Strength)
+
+    t, b, n := rec.tangentFrame()
+    perturbed := t.Scale(x).Add(b.Scale(y)).Add(n.Scale(z))
+    if perturbed.NearZero() {
+        return *rec
+    }
+    return rec.withShadingNormal(perturbed)
+}
+
+func (mat *NormalMap) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    shading := mat.shade(rec)
+    return mat.Base.Scatter(rayIn, &shading, attenuation, scattered)
+}
+
+func (mat *NormalMap) ScatterSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool {
+    shading := mat.shade(rec)
+    return ScatterSpectral(mat.Base, rayIn, &shading, lambdas, attenuation, scattered)
+}
+
+func (mat *NormalMap) Eval(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64) {
+    return evalLayered(mat.evalLobes(rayIn, rec, dir))
+}
+
+func (mat *NormalMap) evalLobes(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64, bool) {
+    shading := mat.shade(rec)
+    return evalMaterial(mat.Base, rayIn, &shading, dir)
+}
+
+func (mat *NormalMap) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    return mat.Base.Emitted(rayIn, rec)
+}
+
+// BumpMap perturbs the shading normal of Base by treating the luminance of
+// Height as a displacement along the normal. The normal is derived from the
+// finite-difference gradient of the displacement.
+type BumpMap struct {
+    Base Material
+    Height Texture
+    Scale float64
+}
+
+func MakeBumpMap(base Material, height Texture, scale float64) *BumpMap {
+    return &BumpMap{Base: base, Height: height, Scale: scale}
+}
+
+// Displacement at the hit moved by du and dv along the surface, looked up
+// like any other texture of the hit.
+func (mat *BumpMap) displacement(rec *HitRecord, du float64, dv float64) float64 {
+    shifted := *rec
+    shifted.U += du
+    shifted.V += dv
+    shifted.P = *rec.P.Add(rec.Dpdu.Scale(du)).Add(rec.Dpdv.Scale(dv))
+    shifted.ObjectP = *rec.ObjectP.Add(rec.ObjectDpdu.Scale(du)).Add(rec.ObjectDpdv.Scale(dv))
+    h := TextureValue(mat.Height, &shifted)
+    return mat.Scale * h.Luminance()
+}
+
+func (mat *BumpMap) shade(rec *HitRecord) HitRecord {
+    n := rec.outwardNormal()
+    displace := mat.displacement(rec, 0, 0)
+    uDisplace := mat.displacement(rec, bumpDelta, 0)
+    vDisplace := mat.displacement(rec, 0, bumpDelta)
+
+    dpdu := rec.Dpdu.Add(n.Scale((uDisplace - displace) / bumpDelta))
+    dpdv := rec.Dpdv.Add(n.Scale((vDisplace - displace) / bumpDelta))
+    perturbed := dpdu.Cross(dpdv)
+    if perturbed.NearZero() {
+        return *rec
+    }
+    if perturbed.Dot(n) < 0 {
+        perturbed = perturbed.Negate()
+    }
+    return rec.withShadingNormal(perturbed)
+}
+
+func (mat *BumpMap) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    shading := mat.shade(rec)
+    return mat.Base.Scatter(rayIn, &shading, attenuation, scattered)
+}
+
+func (mat *BumpMap) ScatterSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool {
+    shading := mat.shade(rec)
+    return ScatterSpectral(mat.Base, rayIn, &shading, lambdas, attenuation, scattered)
+}
+
+func (mat *BumpMap) Eval(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64) {
+    return evalLayered(mat.evalLobes(rayIn, rec, dir))
+}
+
+func (mat *BumpMap) evalLobes(rayIn *Ray, rec *HitRecord, dir *Vec3) (Color, float64, bool) {
+    shading := mat.shade(rec)
+    return evalMaterial(mat.Base, rayIn, &shading, dir)
+}
+
+func (mat *BumpMap) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    return mat.Base.Emitted(rayIn, rec)
+}
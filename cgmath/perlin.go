@@ -0,0 +1,183 @@
+package cgmath
+
+import (
+    "math"
+    "math/rand"
+    "sync"
+)
+
+const perlinPointCount = 256
+
+// Noise shared by textures built without a constructor.
+var (
+    sharedPerlin *Perlin
+    sharedPerlinOnce sync.Once
+)
+
+// The noise of a texture, or the shared noise when it has none.
+func perlinOrShared(p *Perlin) *Perlin {
+    if p != nil {
+        return p
+    }
+    sharedPerlinOnce.Do(func() {
+        sharedPerlin = MakePerlin()
+    })
+    return sharedPerlin
+}
+
+// Perlin is gradient noise over random unit vectors on the integer lattice.
+type Perlin struct {
+    ranvec [perlinPointCount]Vec3
+    permX, permY, permZ [perlinPointCount]int
+}
+
+// Perlin noise from the global random source.
+func MakePerlin() *Perlin {
+    return MakeSeededPerlin(rand.Int63())
+}
+
+// Perlin noise that is the same for the same seed, so patterns can be
+// reproduced between runs.
+func MakeSeededPerlin(seed int64) *Perlin {
+    r := rand.New(rand.NewSource(seed))
+    p := &Perlin{}
+    for i := 0; i < perlinPointCount; i++ {
+        v := Vec3{2 * r.Float64() - 1, 2 * r.Float64() - 1, 2 * r.Float64() - 1}
+        p.ranvec[i] = *v.UnitVector()
+    }
+    perlinGeneratePerm(r, &p.permX)
+    perlinGeneratePerm(r, &p.permY)
+    perlinGeneratePerm(r, &p.permZ)
+    return p
+}
+
+func perlinGeneratePerm(r *rand.Rand, perm *[perlinPointCount]int) {
+    for i := range perm {
+        perm[i] = i
+    }
+    r.Shuffle(perlinPointCount, func(i, j int) {
+        perm[i], perm[j] = perm[j], perm[i]
+    })
+}
+
+// Noise at p, in [-1, 1].
+func (n *Perlin) Noise(p *Vec3) float64 {
+    u := p.X - math.Floor(p.X)
+    v := p.Y - math.Floor(p.Y)
+    w := p.Z - math.Floor(p.Z)
+    i := int(math.Floor(p.X))
+    j := int(math.Floor(p.Y))
+    k := int(math.Floor(p.Z))
+
+    var c [2][2][2]Vec3
+    for di := 0; di < 2; di++ {
+        for dj := 0; dj < 2; dj++ {
+            for dk := 0; dk < 2; dk++ {
+                c[di][dj][dk] = n.ranvec[
+                    n.permX[(i + di) & 255] ^
+                    n.permY[(j + dj) & 255] ^
+                    n.permZ[(k + dk) & 255]]
+            }
+        }
+    }
+    return perlinInterp(&c, u, v, w)
+}
+
+func perlinInterp(c *[2][2][2]Vec3, u, v, w float64) float64 {
+    uu := u * u * (3 - 2 * u)
+    vv := v * v * (3 - 2 * v)
+    ww := w * w * (3 - 2 * w)
+    accum := 0.0
+    for i := 0; i < 2; i++ {
+        for j := 0; j < 2; j++ {
+            for k := 0; k < 2; k++ {
+                fi, fj, fk := float64(i), float64(j), float64(k)
+                weight := Vec3{u - fi, v - fj, w - fk}
+                accum += (fi * uu + (1 - fi) * (1 - uu)) *
+                    (fj * vv + (1 - fj) * (1 - vv)) *
+                    (fk * ww + (1 - fk) * (1 - ww)) *
+                    c[i][j][k].Dot(&weight)
+            }
+        }
+    }
+    return accum
+}
+
+// Fractal Brownian motion: octaves of noise, each scaled in frequency by
+// lacunarity and in amplitude by gain.
+func (n *Perlin) Fbm(p *Vec3, octaves int, lacunarity float64, gain float64) float64 {
+    accum := 0.0
+    temp := *p
+    weight := 1.0
+    for i := 0; i < octaves; i++ {
+        accum += weight * n.Noise(&temp)
+        weight *= gain
+        temp = *temp.Scale(lacunarity)
+    }
+    return accum
+}
+
+// Turbulence is fBm of the absolute noise, which creases where the noise
+// crosses zero.
+func (n *Perlin) Turbulence(p *Vec3, depth int) float64 {
+    accum := 0.0
+    temp := *p
+    weight := 1.0
+    for i := 0; i < depth; i++ {
+        accum += weight * math.Abs(n.Noise(&temp))
+        weight *= 0.5
+        temp = *temp.Scale(2)
+    }
+    return accum
+}
+
+// Integer hash of a lattice cell, used to place feature points without
+// storing them.
+func hashCell(i, j, k int, seed uint32) uint32 {
+    h := seed ^ uint32(i) * 0x8da6b343 ^ uint32(j) * 0xd8163841 ^ uint32(k) * 0xcb1ab31f
+    h ^= h >> 16
+    h *= 0x7feb352d
+    h ^= h >> 15
+    h *= 0x846ca68b
+    h ^= h >> 16
+    return h
+}
+
+func hashFloat(h uint32) (float64, uint32) {
+    h = h * 747796405 + 2891336453
+    return float64(h >> 8) / float64(1 << 24), h
+}
+
+// Distances from p to the closest and second closest feature points of
+// cellular noise, one feature point per unit cell placed randomly within
+// jitter of the cell center.
+func Worley(p *Vec3, jitter float64, seed uint32) (float64, float64) {
+    i := int(math.Floor(p.X))
+    j := int(math.Floor(p.Y))
+    k := int(math.Floor(p.Z))
+
+    f1, f2 := math.Inf(1), math.Inf(1)
+    for di := -1; di <= 1; di++ {
+        for dj := -1; dj <= 1; dj++ {
+            for dk := -1; dk <= 1; dk++ {
+                h := hashCell(i + di, j + dj, k + dk, seed)
+                var x, y, z float64
+                x, h = hashFloat(h)
+                y, h = hashFloat(h)
+                z, _ = hashFloat(h)
+                feature := Vec3{
+                    float64(i + di) + 0.5 + jitter * (x - 0.5),
+                    float64(j + dj) + 0.5 + jitter * (y - 0.5),
+                    float64(k + dk) + 0.5 + jitter * (z - 0.5),
+                }
+                d := feature.Sub(p).Length()
+                if d < f1 {
+                    f1, f2 = d, f1
+                } else if d < f2 {
+                    f2 = d
+                }
+            }
+        }
+    }
+    return f1, f2
+}
@@ -0,0 +1,239 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Blend between two textures at the same lookup.
+func lerpTextures(a, b Texture, t float64, u float64, v float64, p *Vec3) Color {
+    ca := a.Value(u, v, p)
+    cb := b.Value(u, v, p)
+    return *ca.Lerp(&cb, t)
+}
+
+// FbmTexture is grey fractal Brownian motion noise, mapped to [0, 1].
+type FbmTexture struct {
+    Scale float64
+    Octaves int
+    Lacunarity float64
+    Gain float64
+    Space TextureSpace
+    // Noise source, the shared noise when nil.
+    Noise *Perlin
+}
+
+func MakeFbmTexture(scale float64, octaves int) *FbmTexture {
+    return &FbmTexture{
+        Scale: scale,
+        Octaves: octaves,
+        Lacunarity: 2,
+        Gain: 0.5,
+        Space: SolidSpace,
+        Noise: MakePerlin(),
+    }
+}
+
+func (t *FbmTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := texturePoint(t.Space, u, v, p)
+    n := perlinOrShared(t.Noise).Fbm(q.Scale(t.Scale), t.Octaves, t.Lacunarity, t.Gain)
+    g := Clamp(0.5 * (1 + n), 0, 1)
+    return Color{g, g, g}
+}
+
+// Which distance of cellular noise a WorleyTexture shows.
+type WorleyFeature int
+
+const (
+    // Distance to the closest feature point, giving round cells.
+    WorleyF1 WorleyFeature = iota
+    // Distance to the second closest feature point.
+    WorleyF2
+    // Difference of the two, which is zero on the cell borders.
+    WorleyF2MinusF1
+)
+
+// WorleyTexture is grey cellular noise, with Scale cells per unit.
+type WorleyTexture struct {
+    Scale float64
+    // How far feature points stray from their cell centers, in [0, 1].
+    Jitter float64
+    Feature WorleyFeature
+    Space TextureSpace
+    Seed uint32
+}
+
+func MakeWorleyTexture(scale float64, feature WorleyFeature) *WorleyTexture {
+    return &WorleyTexture{Scale: scale, Jitter: 1, Feature: feature, Space: SolidSpace}
+}
+
+func (t *WorleyTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := texturePoint(t.Space, u, v, p)
+    f1, f2 := Worley(q.Scale(t.Scale), t.Jitter, t.Seed)
+    var g float64
+    switch t.Feature {
+        case WorleyF1:
+            g = f1
+        case WorleyF2:
+            g = f2
+        case WorleyF2MinusF1:
+            g = f2 - f1
+    }
+    g = Clamp(g, 0, 1)
+    return Color{g, g, g}
+}
+
+// The point of a solid pattern laid out in the xz plane, with u and v in
+// place of x and z in UV space.
+func planarPoint(space TextureSpace, u float64, v float64, p *Vec3) Vec3 {
+    if space == UvSpace {
+        return Vec3{u, 0, v}
+    }
+    return *p
+}
+
+// MarbleTexture is veins of B through A, sine bands along z perturbed by
+// turbulence. In UV space the bands run along v.
+type MarbleTexture struct {
+    A, B Texture
+    Scale float64
+    Turbulence float64
+    Octaves int
+    Space TextureSpace
+    // Noise source, the shared noise when nil.
+    Noise *Perlin
+}
+
+func MakeMarbleTexture(a, b Texture, scale float64) *MarbleTexture {
+    return &MarbleTexture{
+        A: a,
+        B: b,
+        Scale: scale,
+        Turbulence: 10,
+        Octaves: 7,
+        Space: SolidSpace,
+        Noise: MakePerlin(),
+    }
+}
+
+// The grey marble of the classic noise texture.
+func MakeNoiseTexture(scale float64) *MarbleTexture {
+    return MakeMarbleTexture(MakeSolidColor(0, 0, 0), MakeSolidColor(1, 1, 1), scale)
+}
+
+func (t *MarbleTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := planarPoint(t.Space, u, v, p)
+    turb := perlinOrShared(t.Noise).Turbulence(&q, t.Octaves)
+    s := 0.5 * (1 + math.Sin(t.Scale * q.Z + t.Turbulence * turb))
+    return lerpTextures(t.A, t.B, s, u, v, p)
+}
+
+// WoodTexture is growth rings of Dark in Light around the y axis, RingScale
+// rings per unit, distorted by turbulence. In UV space the rings are
+// centered on the UV origin.
+type WoodTexture struct {
+    Light, Dark Texture
+    RingScale float64
+    Turbulence float64
+    Octaves int
+    Space TextureSpace
+    // Noise source, the shared noise when nil.
+    Noise *Perlin
+}
+
+func MakeWoodTexture(light, dark Texture, ringScale float64) *WoodTexture {
+    return &WoodTexture{
+        Light: light,
+        Dark: dark,
+        RingScale: ringScale,
+        Turbulence: 0.5,
+        Octaves: 4,
+        Space: SolidSpace,
+        Noise: MakePerlin(),
+    }
+}
+
+func (t *WoodTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := planarPoint(t.Space, u, v, p)
+    r := math.Sqrt(q.X * q.X + q.Z * q.Z) * t.RingScale
+    r += t.Turbulence * perlinOrShared(t.Noise).Turbulence(&q, t.Octaves)
+    ring := r - math.Floor(r)
+    // Sharp late wood at the end of each ring, fading into early wood.
+    s := math.Pow(ring, 3)
+    return lerpTextures(t.Light, t.Dark, s, u, v, p)
+}
+
+// BrickTexture is running bond bricks separated by mortar. In solid space
+// courses are stacked along y and bricks run along both x and z, in UV space
+// courses are stacked along v.
+type BrickTexture struct {
+    Brick, Mortar Texture
+    Width, Height float64
+    MortarWidth float64
+    Space TextureSpace
+}
+
+func MakeBrickTexture(brick, mortar Texture, width, height, mortarWidth float64) *BrickTexture {
+    return &BrickTexture{
+        Brick: brick,
+        Mortar: mortar,
+        Width: width,
+        Height: height,
+        MortarWidth: mortarWidth,
+        Space: SolidSpace,
+    }
+}
+
+// Whether x is within half the joint width of a multiple of size.
+func nearJoint(x float64, size float64, width float64) bool {
+    f := x / size
+    f -= math.Floor(f)
+    half := 0.5 * width / size
+    return f < half || f > 1 - half
+}
+
+func (t *BrickTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := texturePoint(t.Space, u, v, p)
+    row := math.Floor(q.Y / t.Height)
+    // Every other course is offset by half a brick.
+    offset := 0.0
+    if int(row) & 1 == 1 {
+        offset = 0.5 * t.Width
+    }
+
+    mortar := nearJoint(q.Y, t.Height, t.MortarWidth) ||
+        nearJoint(q.X + offset, t.Width, t.MortarWidth)
+    if t.Space == SolidSpace {
+        mortar = mortar || nearJoint(q.Z + offset, t.Width, t.MortarWidth)
+    }
+    if mortar {
+        return t.Mortar.Value(u, v, p)
+    }
+    return t.Brick.Value(u, v, p)
+}
+
+// GridTexture draws lines of Line over Background, Scale cells per unit.
+// In solid space the lines are where the cells of a lattice meet the
+// surface, in UV space there are Scale cells along each of u and v.
+type GridTexture struct {
+    Line, Background Texture
+    Scale float64
+    // Width of the lines as a fraction of a cell.
+    LineWidth float64
+    Space TextureSpace
+}
+
+func MakeGridTexture(line, background Texture, scale float64, lineWidth float64) *GridTexture {
+    return &GridTexture{Line: line, Background: background, Scale: scale, LineWidth: lineWidth, Space: SolidSpace}
+}
+
+func (t *GridTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := texturePoint(t.Space, u, v, p)
+    line := nearJoint(q.X * t.Scale, 1, t.LineWidth) || nearJoint(q.Y * t.Scale, 1, t.LineWidth)
+    if t.Space == SolidSpace {
+        line = line || nearJoint(q.Z * t.Scale, 1, t.LineWidth)
+    }
+    if line {
+        return t.Line.Value(u, v, p)
+    }
+    return t.Background.Value(u, v, p)
+}
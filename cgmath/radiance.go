@@ -0,0 +1,161 @@
+package cgmath
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "strings"
+)
+
+// Decode a Radiance RGBE (.hdr) image into linear float colors. Scanlines
+// may be flat, old style run-length encoded or new style run-length encoded
+// per channel.
+func decodeRadiance(r io.Reader) (imageLevel, error) {
+    br := bufio.NewReader(r)
+
+    magic, err := br.ReadString('\n')
+    if err != nil {
+        return imageLevel{}, err
+    }
+    if !strings.HasPrefix(magic, "#?") {
+        return imageLevel{}, errors.New("not a Radiance image")
+    }
+    for {
+        line, err := br.ReadString('\n')
+        if err != nil {
+            return imageLevel{}, err
+        }
+        line = strings.TrimSpace(line)
+        if line == "" {
+            break
+        }
+        if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+            return imageLevel{}, fmt.Errorf("unsupported Radiance format %q", line[len("FORMAT="):])
+        }
+    }
+
+    resolution, err := br.ReadString('\n')
+    if err != nil {
+        return imageLevel{}, err
+    }
+    var yAxis, xAxis string
+    var width, height int
+    if _, err := fmt.Sscanf(resolution, "%s %d %s %d", &yAxis, &height, &xAxis, &width); err != nil {
+        return imageLevel{}, fmt.Errorf("bad Radiance resolution %q", strings.TrimSpace(resolution))
+    }
+    if (yAxis != "-Y" && yAxis != "+Y") || xAxis != "+X" {
+        return imageLevel{}, fmt.Errorf("unsupported Radiance orientation %q", strings.TrimSpace(resolution))
+    }
+    if err := checkImageSize(width, height); err != nil {
+        return imageLevel{}, err
+    }
+
+    level := imageLevel{pixels: make([]Color, width * height), width: width, height: height}
+    scanline := make([][4]byte, width)
+    for j := 0; j < height; j++ {
+        if err := readRgbeScanline(br, scanline); err != nil {
+            return imageLevel{}, err
+        }
+        // +Y images are stored bottom to top.
+        row := j
+        if yAxis == "+Y" {
+            row = height - 1 - j
+        }
+        for i, rgbe := range scanline {
+            level.pixels[row * width + i] = rgbeToColor(rgbe)
+        }
+    }
+    return level, nil
+}
+
+func rgbeToColor(rgbe [4]byte) Color {
+    if rgbe[3] == 0 {
+        return Color{0, 0, 0}
+    }
+    f := math.Ldexp(1, int(rgbe[3]) - (128 + 8))
+    return Color{float64(rgbe[0]) * f, float64(rgbe[1]) * f, float64(rgbe[2]) * f}
+}
+
+func readRgbeScanline(br *bufio.Reader, scanline [][4]byte) error {
+    width := len(scanline)
+    var first [4]byte
+    if _, err := io.ReadFull(br, first[:]); err != nil {
+        return err
+    }
+
+    if width < 8 || width > 0x7fff || first[0] != 2 || first[1] != 2 || first[2] & 0x80 != 0 {
+        return readOldRgbeScanline(br, scanline, first)
+    }
+    if int(first[2]) << 8 | int(first[3]) != width {
+        return errors.New("Radiance scanline width mismatch")
+    }
+
+    // New style: each channel is run-length encoded in turn.
+    for c := 0; c < 4; c++ {
+        for i := 0; i < width; {
+            count, err := br.ReadByte()
+            if err != nil {
+                return err
+            }
+            if count > 128 {
+                n := int(count) - 128
+                value, err := br.ReadByte()
+                if err != nil {
+                    return err
+                }
+                if i + n > width {
+                    return errors.New("Radiance run overflows scanline")
+                }
+                for ; n > 0; n-- {
+                    scanline[i][c] = value
+                    i++
+                }
+            } else {
+                n := int(count)
+                if n == 0 || i + n > width {
+                    return errors.New("bad Radiance run length")
+                }
+                for ; n > 0; n-- {
+                    value, err := br.ReadByte()
+                    if err != nil {
+                        return err
+                    }
+                    scanline[i][c] = value
+                    i++
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// Flat pixels, where a pixel of (1, 1, 1, n) repeats the previous pixel.
+func readOldRgbeScanline(br *bufio.Reader, scanline [][4]byte, first [4]byte) error {
+    shift := uint(0)
+    pixel := first
+    for i := 0; i < len(scanline); {
+        if i > 0 {
+            if _, err := io.ReadFull(br, pixel[:]); err != nil {
+                return err
+            }
+        }
+        if pixel[0] == 1 && pixel[1] == 1 && pixel[2] == 1 && i > 0 {
+            n := int(pixel[3]) << shift
+            if i + n > len(scanline) {
+                return errors.New("Radiance run overflows scanline")
+            }
+            for ; n > 0; n-- {
+                scanline[i] = scanline[i - 1]
+                i++
+            }
+            shift += 8
+            continue
+        }
+        scanline[i] = pixel
+        i++
+        shift = 0
+    }
+    return nil
+}
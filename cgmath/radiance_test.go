@@ -0,0 +1,143 @@
+package cgmath
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// RGBE of a pixel whose color is its first three bytes, repeating in threes
+// along the rows.
+func radianceTestPixel(x int, y int) [4]byte {
+    return [4]byte{byte(16 * (x / 3) + 3), byte(y + 5), 7, 136}
+}
+
+func encodeRadiance(yAxis string, width int, height int, scanline func(row [][4]byte) []byte) []byte {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n%s %d +X %d\n", yAxis, height, width)
+    for j := 0; j < height; j++ {
+        // Rows are stored from the top for -Y and from the bottom for +Y.
+        y := j
+        if yAxis == "+Y" {
+            y = height - 1 - j
+        }
+        row := make([][4]byte, width)
+        for x := range row {
+            row[x] = radianceTestPixel(x, y)
+        }
+        b.Write(scanline(row))
+    }
+    return b.Bytes()
+}
+
+func flatScanline(row [][4]byte) []byte {
+    var b []byte
+    for _, p := range row {
+        b = append(b, p[:]...)
+    }
+    return b
+}
+
+// Old style runs repeat the previous pixel.
+func oldRleScanline(row [][4]byte) []byte {
+    var b []byte
+    for i := 0; i < len(row); {
+        b = append(b, row[i][:]...)
+        n := 1
+        for i + n < len(row) && row[i + n] == row[i] {
+            n++
+        }
+        if n > 1 {
+            b = append(b, 1, 1, 1, byte(n - 1))
+        }
+        i += n
+    }
+    return b
+}
+
+// New style scanlines run-length encode constant channels and dump the
+// others.
+func newRleScanline(row [][4]byte) []byte {
+    b := []byte{2, 2, byte(len(row) >> 8), byte(len(row))}
+    for c := 0; c < 4; c++ {
+        constant := true
+        for _, p := range row {
+            constant = constant && p[c] == row[0][c]
+        }
+        if constant {
+            b = append(b, byte(128 + len(row)), row[0][c])
+            continue
+        }
+        b = append(b, byte(len(row)))
+        for _, p := range row {
+            b = append(b, p[c])
+        }
+    }
+    return b
+}
+
+func TestDecodeRadiance(t *testing.T) {
+    tests := []struct {
+        name string
+        yAxis string
+        width int
+        scanline func(row [][4]byte) []byte
+    }{
+        {"flat", "-Y", 9, flatScanline},
+        {"flat bottom up", "+Y", 9, flatScanline},
+        {"old run-length", "-Y", 9, oldRleScanline},
+        {"new run-length", "-Y", 9, newRleScanline},
+        {"new run-length bottom up", "+Y", 9, newRleScanline},
+    }
+    const height = 3
+    for _, tt := range tests {
+        data := encodeRadiance(tt.yAxis, tt.width, height, tt.scanline)
+        level, err := decodeRadiance(bytes.NewReader(data))
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if level.width != tt.width || level.height != height {
+            t.Errorf("%s: size %dx%d, want %dx%d", tt.name, level.width, level.height, tt.width, height)
+            continue
+        }
+        for y := 0; y < height; y++ {
+            for x := 0; x < tt.width; x++ {
+                p := radianceTestPixel(x, y)
+                want := Color{float64(p[0]), float64(p[1]), float64(p[2])}
+                if got := level.pixels[y * tt.width + x]; got != want {
+                    t.Fatalf("%s: pixel (%d, %d) is %v, want %v", tt.name, x, y, got, want)
+                }
+            }
+        }
+
+        for n := 0; n < len(data); n++ {
+            if _, err := decodeRadiance(bytes.NewReader(data[:n])); err == nil {
+                t.Fatalf("%s: truncated to %d of %d bytes decoded", tt.name, n, len(data))
+            }
+        }
+    }
+}
+
+func TestDecodeRadianceMalformed(t *testing.T) {
+    header := "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n"
+    tests := []struct {
+        name string
+        data string
+    }{
+        {"not an image", "P6\n1 1\n255\n"},
+        {"other format", "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n\x01\x02\x03\x88"},
+        {"bad resolution", header + "-Y one +X 1\n"},
+        {"rotated", header + "+X 1 -Y 1\n\x01\x02\x03\x88"},
+        {"huge", header + "-Y 100000000 +X 100000000\n"},
+        {"run past the scanline", header + "-Y 1 +X 8\n\x02\x02\x00\x08\x89\x01"},
+        {"zero length dump", header + "-Y 1 +X 8\n\x02\x02\x00\x08\x00"},
+        {"width mismatch", header + "-Y 1 +X 8\n\x02\x02\x00\x09"},
+    }
+    for _, tt := range tests {
+        if _, err := decodeRadiance(strings.NewReader(tt.data)); err == nil {
+            t.Errorf("%s: decoded", tt.name)
+        }
+    }
+}
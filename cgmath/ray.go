@@ -3,8 +3,40 @@ package cgmath
 type Ray struct {
     Orig, Dir Vec3
     Time float64
+    // Wavelength in nanometers the path is restricted to after a wavelength
+    // dependent event like dispersion. Zero while the path still carries all
+    // of RGB.
+    Wavelength float64
+    // Rays through the neighboring pixels in x and y, used to estimate the
+    // footprint of the ray on the surfaces it hits.
+    HasDifferentials bool
+    RxOrig, RxDir Vec3
+    RyOrig, RyDir Vec3
 }
 
 func (r *Ray) At(t float64) *Vec3 {
     return r.Orig.Add(r.Dir.Scale(t))
 }
+
+// Spawn a ray continuing the path of r from orig in direction dir. The ray
+// differentials are dropped, as after a diffuse bounce they no longer
+// describe a useful footprint.
+func (r *Ray) Spawn(orig *Vec3, dir *Vec3) Ray {
+    return Ray{Orig: *orig, Dir: *dir, Time: r.Time, Wavelength: r.Wavelength}
+}
+
+// Spawn a ray for a perfectly specular bounce at rec. The differentials are
+// carried along by bouncing them with the same function that maps the unit
+// incident direction to the outgoing one, treating the surface as locally
+// flat.
+func (r *Ray) SpawnSpecular(rec *HitRecord, dir *Vec3, bounce func(d *Vec3) *Vec3) Ray {
+    s := r.Spawn(&rec.P, dir)
+    if r.HasDifferentials && rec.HasDifferentials {
+        s.HasDifferentials = true
+        s.RxOrig = *rec.P.Add(&rec.Dpdx)
+        s.RyOrig = *rec.P.Add(&rec.Dpdy)
+        s.RxDir = *bounce(r.RxDir.UnitVector())
+        s.RyDir = *bounce(r.RyDir.UnitVector())
+    }
+    return s
+}
@@ -0,0 +1,13 @@
+package cgmath
+
+// Scene is everything a render needs besides the camera: the geometry, the
+// lights without geometry, and the environment around both.
+type Scene struct {
+    World Hittable
+    Lights []Light
+    Background Environment
+}
+
+func (s *Scene) AddLight(l Light) {
+    s.Lights = append(s.Lights, l)
+}
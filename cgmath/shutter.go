@@ -0,0 +1,89 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// ShutterCurve is how far the shutter is open over the time it takes to open
+// and close. Ray times are spread in proportion to it, which shapes the
+// streaks of motion blur.
+type ShutterCurve interface {
+    // Sample a time in [0, 1] of the shutter interval.
+    Sample() float64
+}
+
+// BoxShutter opens and closes instantly, blurring moving objects evenly.
+type BoxShutter struct{}
+
+func (s *BoxShutter) Sample() float64 {
+    return Rand()
+}
+
+// TrapezoidShutter opens linearly until the fraction Opening of the interval
+// and closes linearly over the last fraction Closing of it, like the blades
+// of a mechanical shutter. Motion blur fades out at both ends.
+type TrapezoidShutter struct {
+    Opening, Closing float64
+}
+
+func (s *TrapezoidShutter) Sample() float64 {
+    a := Clamp(s.Opening, 0, 1)
+    b := Clamp(s.Closing, 0, 1 - a)
+
+    // Invert the area under the curve, split into the ramps and the flat top.
+    rampUp := a / 2
+    flat := 1 - a - b
+    area := Rand() * (rampUp + flat + b / 2)
+    switch {
+    case area < rampUp:
+        return math.Sqrt(2 * a * area)
+    case area < rampUp + flat:
+        return a + area - rampUp
+    default:
+        return 1 - math.Sqrt(2 * b * (rampUp + flat + b / 2 - area))
+    }
+}
+
+// CurveShutter follows a measured curve of how far the shutter is open, with
+// values spaced evenly over the interval, sampled by its cumulative
+// distribution. Without values it opens like a BoxShutter.
+type CurveShutter struct {
+    distribution *distribution1D
+}
+
+func MakeCurveShutter(openness []float64) *CurveShutter {
+    if len(openness) == 0 {
+        return &CurveShutter{}
+    }
+    fn := make([]float64, len(openness))
+    for i, v := range openness {
+        fn[i] = math.Max(v, 0)
+    }
+    return &CurveShutter{distribution: makeDistribution1D(fn)}
+}
+
+func (s *CurveShutter) Sample() float64 {
+    if s.distribution == nil {
+        return Rand()
+    }
+    t, _, _ := s.distribution.sample(Rand())
+    return t
+}
+
+// Time of a ray through image row v, from the top row at v = 1 to the bottom
+// at v = 0. With a rolling shutter the rows are read out one after the other
+// over the fraction RollingShutter of the shutter interval, each exposed for
+// the rest of it, so fast motion slants and warps.
+func (c *cameraBase) sampleTime(v float64) float64 {
+    t := 0.0
+    if c.Shutter == nil {
+        t = Rand()
+    } else {
+        t = c.Shutter.Sample()
+    }
+    if c.RollingShutter > 0 {
+        readout := Clamp(c.RollingShutter, 0, 1)
+        t = (1 - Clamp(v, 0, 1)) * readout + t * (1 - readout)
+    }
+    return c.ShutterOpen + t * (c.ShutterClose - c.ShutterOpen)
+}
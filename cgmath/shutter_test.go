@@ -0,0 +1,92 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+// Fraction of samples of the shutter falling in each of bins equal parts of
+// the interval.
+func shutterHistogram(t *testing.T, shutter ShutterCurve, bins int, samples int) []float64 {
+    histogram := make([]float64, bins)
+    for i := 0; i < samples; i++ {
+        s := shutter.Sample()
+        if s < 0 || s > 1 {
+            t.Fatalf("sample %g outside [0, 1]", s)
+        }
+        histogram[int(math.Min(s * float64(bins), float64(bins - 1)))] += 1.0 / float64(samples)
+    }
+    return histogram
+}
+
+// Openness of a trapezoid opening over [0, a] and closing over [1 - b, 1].
+func trapezoidOpenness(a, b, t float64) float64 {
+    switch {
+    case t < a:
+        return t / a
+    case t > 1 - b:
+        return (1 - t) / b
+    default:
+        return 1
+    }
+}
+
+func TestShutterCurves(t *testing.T) {
+    tests := []struct {
+        name string
+        shutter ShutterCurve
+        openness func(t float64) float64
+    }{
+        {"box", &BoxShutter{}, func(t float64) float64 { return 1 }},
+        {"trapezoid box", &TrapezoidShutter{}, func(t float64) float64 { return 1 }},
+        {"trapezoid", &TrapezoidShutter{Opening: 0.2, Closing: 0.3}, func(t float64) float64 {
+            return trapezoidOpenness(0.2, 0.3, t)
+        }},
+        {"triangle", &TrapezoidShutter{Opening: 0.5, Closing: 0.5}, func(t float64) float64 {
+            return trapezoidOpenness(0.5, 0.5, t)
+        }},
+        {"opening only", &TrapezoidShutter{Opening: 1}, func(t float64) float64 { return t }},
+        // Ramps longer than the interval are clamped.
+        {"clamped", &TrapezoidShutter{Opening: 0.8, Closing: 0.8}, func(t float64) float64 {
+            return trapezoidOpenness(0.8, 0.2, t)
+        }},
+        {"curve", MakeCurveShutter([]float64{0, 1, 3, -1}), func(t float64) float64 {
+            return []float64{0, 1, 3, 0}[int(math.Min(4 * t, 3))]
+        }},
+        {"empty curve", MakeCurveShutter(nil), func(t float64) float64 { return 1 }},
+    }
+    const bins = 20
+    for _, tt := range tests {
+        // Expected fraction of each bin from the midpoint rule on a fine
+        // grid.
+        expected := make([]float64, bins)
+        total := 0.0
+        const steps = 100
+        for i := 0; i < bins * steps; i++ {
+            v := tt.openness((float64(i) + 0.5) / (bins * steps))
+            expected[i / steps] += v
+            total += v
+        }
+
+        histogram := shutterHistogram(t, tt.shutter, bins, 200000)
+        for i := range histogram {
+            if want := expected[i] / total; math.Abs(histogram[i] - want) > 0.005 {
+                t.Errorf("%s: fraction %g in bin %d, want %g", tt.name, histogram[i], i, want)
+            }
+        }
+    }
+}
+
+func TestRollingShutterTime(t *testing.T) {
+    c := cameraBase{ShutterOpen: 1, ShutterClose: 3, RollingShutter: 0.5}
+    for i := 0; i < 1000; i++ {
+        // The top row is read out first and the bottom row last, each
+        // exposed for half of the interval.
+        if top := c.sampleTime(1); top < 1 || top > 2 {
+            t.Fatalf("top row time %g outside [1, 2]", top)
+        }
+        if bottom := c.sampleTime(0); bottom < 2 || bottom > 3 {
+            t.Fatalf("bottom row time %g outside [2, 3]", bottom)
+        }
+    }
+}
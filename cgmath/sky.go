@@ -0,0 +1,234 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Angular diameter of the sun seen from the earth, in degrees.
+const SunAngularDiameter = 0.53
+
+// Luminance of the sun above the atmosphere in kcd/m², the unit of the sky
+// model.
+const sunLuminance = 1.6e6
+
+// Coefficients A to E of the Perez sky distribution function.
+type perezCoefficients [5]float64
+
+func (p *perezCoefficients) eval(cosTheta float64, gamma float64) float64 {
+    cosGamma := math.Cos(gamma)
+    return (1 + p[0] * math.Exp(p[1] / cosTheta)) *
+        (1 + p[2] * math.Exp(p[3] * gamma) + p[4] * cosGamma * cosGamma)
+}
+
+// PreethamSky is the analytic daylight sky of Preetham, Shirley and Smits
+// (1999) together with the disk of the sun. Below the horizon is a diffuse
+// ground lit by both. Radiance is in kcd/m² times Intensity.
+type PreethamSky struct {
+    Intensity float64
+    sunDir Vec3
+    turbidity float64
+    groundAlbedo Color
+    // Perez coefficients and zenith values of Y, x and y.
+    perez [3]perezCoefficients
+    zenith [3]float64
+    sunRadiance Color
+    cosSunRadius float64
+    ground Color
+    // Fraction of samples spent on the sun disk.
+    sunProbability float64
+    // Tabulated sky, only used to importance sample it.
+    skyMap *EnvironmentMap
+}
+
+// Make a sky with the sun at the given elevation above the horizon and
+// azimuth from the +z axis towards +x, both in degrees. Turbidity is the
+// haziness of the atmosphere, from 2 for a very clear to 10 for a hazy sky.
+func MakePreethamSky(elevation float64, azimuth float64, turbidity float64, groundAlbedo Color) *PreethamSky {
+    el := DegToRad(elevation)
+    az := DegToRad(azimuth)
+    s := &PreethamSky{
+        Intensity: 1,
+        sunDir: Vec3{math.Cos(el) * math.Sin(az), math.Sin(el), math.Cos(el) * math.Cos(az)},
+        turbidity: math.Max(turbidity, 1.7),
+        groundAlbedo: groundAlbedo,
+        cosSunRadius: math.Cos(DegToRad(0.5 * SunAngularDiameter)),
+    }
+
+    t := s.turbidity
+    s.perez[0] = perezCoefficients{
+        0.1787 * t - 1.4630, -0.3554 * t + 0.4275, -0.0227 * t + 5.3251,
+        0.1206 * t - 2.5771, -0.0670 * t + 0.3703,
+    }
+    s.perez[1] = perezCoefficients{
+        -0.0193 * t - 0.2592, -0.0665 * t + 0.0008, -0.0004 * t + 0.2125,
+        -0.0641 * t - 0.8989, -0.0033 * t + 0.0452,
+    }
+    s.perez[2] = perezCoefficients{
+        -0.0167 * t - 0.2608, -0.0950 * t + 0.0092, -0.0079 * t + 0.2102,
+        -0.0441 * t - 1.6537, -0.0109 * t + 0.0529,
+    }
+
+    // The model only holds for the sun above the horizon.
+    thetaS := math.Min(math.Pi / 2 - el, math.Pi / 2)
+    th2 := thetaS * thetaS
+    th3 := th2 * thetaS
+    chi := (4.0 / 9.0 - t / 120) * (math.Pi - 2 * thetaS)
+    s.zenith[0] = math.Max((4.0453 * t - 4.9710) * math.Tan(chi) - 0.2155 * t + 2.4192, 0)
+    s.zenith[1] = t * t * (0.00166 * th3 - 0.00375 * th2 + 0.00209 * thetaS) +
+        t * (-0.02903 * th3 + 0.06377 * th2 - 0.03202 * thetaS + 0.00394) +
+        (0.11693 * th3 - 0.21196 * th2 + 0.06052 * thetaS + 0.25886)
+    s.zenith[2] = t * t * (0.00275 * th3 - 0.00610 * th2 + 0.00317 * thetaS) +
+        t * (-0.04214 * th3 + 0.08970 * th2 - 0.04153 * thetaS + 0.00516) +
+        (0.15346 * th3 - 0.26756 * th2 + 0.06670 * thetaS + 0.26688)
+    // Normalize by the distribution at the zenith.
+    for i := range s.zenith {
+        s.zenith[i] /= s.perez[i].eval(1, thetaS)
+    }
+
+    if elevation > 0 {
+        s.sunRadiance = sunTransmittance(thetaS, t)
+    }
+
+    s.tabulate()
+    return s
+}
+
+// Radiance of the sun disk after passing through the atmosphere along the
+// zenith angle thetaS, from Rayleigh and aerosol extinction of a 5778 K
+// black body spectrum.
+func sunTransmittance(thetaS float64, turbidity float64) Color {
+    // Relative optical mass of the atmosphere.
+    m := 1 / (math.Cos(thetaS) + 0.15 * math.Pow(93.885 - thetaS * 180 / math.Pi, -1.253))
+    beta := 0.04608 * turbidity - 0.04586
+
+    var x, y, z, y0 float64
+    for lambda := LambdaMin; lambda <= LambdaMax; lambda++ {
+        um := lambda / 1000
+        rayleigh := math.Exp(-0.008735 * math.Pow(um, -4.08) * m)
+        aerosol := math.Exp(-beta * math.Pow(um, -1.3) * m)
+        b := Blackbody(lambda, 5778)
+        y0 += b * CieY(lambda)
+        b *= rayleigh * aerosol
+        x += b * CieX(lambda)
+        y += b * CieY(lambda)
+        z += b * CieZ(lambda)
+    }
+    scale := sunLuminance / y0
+    return WorkingSpace.FromXyz(x * scale, y * scale, z * scale)
+}
+
+// Radiance of the sky alone, without the sun disk, along a unit direction
+// above the horizon.
+func (s *PreethamSky) skyRadiance(dir *Vec3) Color {
+    cosTheta := math.Max(dir.Y, 0.01)
+    gamma := math.Acos(Clamp(dir.Dot(&s.sunDir), -1, 1))
+
+    Y := s.zenith[0] * s.perez[0].eval(cosTheta, gamma)
+    x := s.zenith[1] * s.perez[1].eval(cosTheta, gamma)
+    y := s.zenith[2] * s.perez[2].eval(cosTheta, gamma)
+    if y <= 0 || Y <= 0 {
+        return Color{0, 0, 0}
+    }
+    c := WorkingSpace.FromXyz(x / y * Y, Y, (1 - x - y) / y * Y)
+    return Color{math.Max(c.R, 0), math.Max(c.G, 0), math.Max(c.B, 0)}
+}
+
+func (s *PreethamSky) radiance(dir *Vec3) Color {
+    if dir.Y < 0 {
+        return s.ground
+    }
+    return s.skyRadiance(dir)
+}
+
+// Tabulate the sky to light the ground and to sample it.
+func (s *PreethamSky) tabulate() {
+    const width, height = 128, 64
+
+    pixels := make([]Color, width * height)
+    var irradiance Color
+    for j := 0; j < height; j++ {
+        v := 1 - (float64(j) + 0.5) / height
+        for i := 0; i < width; i++ {
+            dir := equirectDirection((float64(i) + 0.5) / width, v)
+            if dir.Y <= 0 {
+                continue
+            }
+            c := s.skyRadiance(&dir)
+            pixels[j * width + i] = c
+            solidAngle := 2 * math.Pi * math.Pi / (width * height) * math.Sin(math.Pi * v)
+            irradiance.Accumulate(c.Scale(dir.Y * solidAngle))
+        }
+    }
+
+    sunSolidAngle := 2 * math.Pi * (1 - s.cosSunRadius)
+    irradiance.Accumulate(s.sunRadiance.Scale(math.Max(s.sunDir.Y, 0) * sunSolidAngle))
+    s.ground = *irradiance.Mul(&s.groundAlbedo).Scale(1 / math.Pi)
+
+    var skyPower float64
+    for j := height / 2; j < height; j++ {
+        v := 1 - (float64(j) + 0.5) / height
+        for i := 0; i < width; i++ {
+            pixels[j * width + i] = s.ground
+        }
+        skyPower += s.ground.Luminance() * width * math.Sin(math.Pi * v)
+    }
+    for j := 0; j < height / 2; j++ {
+        v := 1 - (float64(j) + 0.5) / height
+        for i := 0; i < width; i++ {
+            skyPower += pixels[j * width + i].Luminance() * math.Sin(math.Pi * v)
+        }
+    }
+    skyPower *= 2 * math.Pi * math.Pi / (width * height)
+
+    sunPower := s.sunRadiance.Luminance() * sunSolidAngle
+    if sunPower > 0 {
+        s.sunProbability = math.Min(sunPower / (sunPower + skyPower), 0.9)
+    }
+
+    // The table always fills the image.
+    texture, _ := MakeImageTextureFromPixels(pixels, width, height, RawEncoding)
+    texture.Filter = NearestFilter
+    s.skyMap = MakeEnvironmentMap(texture)
+}
+
+// Unit direction towards the sun.
+func (s *PreethamSky) SunDirection() Vec3 {
+    return s.sunDir
+}
+
+// Radiance of the sun disk, zero when the sun is below the horizon.
+func (s *PreethamSky) SunRadiance() Color {
+    return *s.sunRadiance.Scale(s.Intensity)
+}
+
+func (s *PreethamSky) inSun(dir *Vec3) bool {
+    return s.sunProbability > 0 && dir.Dot(&s.sunDir) >= s.cosSunRadius
+}
+
+func (s *PreethamSky) Radiance(dir *Vec3) Color {
+    d := dir.UnitVector()
+    c := s.radiance(d)
+    if s.inSun(d) {
+        c.Accumulate(&s.sunRadiance)
+    }
+    return *c.Scale(s.Intensity)
+}
+
+func (s *PreethamSky) Sample() (Vec3, Color, float64) {
+    var dir Vec3
+    if Rand() < s.sunProbability {
+        dir = *RandomInCone(&s.sunDir, s.cosSunRadius)
+    } else {
+        dir, _, _ = s.skyMap.Sample()
+    }
+    return dir, s.Radiance(&dir), s.Pdf(&dir)
+}
+
+func (s *PreethamSky) Pdf(dir *Vec3) float64 {
+    d := dir.UnitVector()
+    pdf := (1 - s.sunProbability) * s.skyMap.Pdf(d)
+    if s.inSun(d) {
+        pdf += s.sunProbability * ConePdf(s.cosSunRadius)
+    }
+    return pdf
+}
@@ -0,0 +1,415 @@
+package cgmath
+
+import (
+    "math"
+    "sync"
+)
+
+// Number of wavelengths traced together along one path in spectral mode.
+const NSpectrumSamples = 4
+
+// SampledWavelengths are the wavelengths, in nanometers, a spectral path is
+// traced at together with the probability density each was sampled with. The
+// first one is the hero wavelength, the others are evenly rotated around the
+// visible range.
+type SampledWavelengths struct {
+    Lambda [NSpectrumSamples]float64
+    Pdf [NSpectrumSamples]float64
+}
+
+func SampleHeroWavelengths() SampledWavelengths {
+    var w SampledWavelengths
+    hero := SampleWavelength()
+    delta := (LambdaMax - LambdaMin) / NSpectrumSamples
+    for i := 0; i < NSpectrumSamples; i++ {
+        lambda := hero + float64(i) * delta
+        if lambda > LambdaMax {
+            lambda -= LambdaMax - LambdaMin
+        }
+        w.Lambda[i] = lambda
+        w.Pdf[i] = 1.0 / (LambdaMax - LambdaMin)
+    }
+    return w
+}
+
+func (w *SampledWavelengths) SecondaryTerminated() bool {
+    for i := 1; i < NSpectrumSamples; i++ {
+        if w.Pdf[i] != 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// Drop all but the hero wavelength, for events like dispersion that send each
+// wavelength in a different direction.
+func (w *SampledWavelengths) TerminateSecondary() {
+    if w.SecondaryTerminated() {
+        return
+    }
+    for i := 1; i < NSpectrumSamples; i++ {
+        w.Pdf[i] = 0
+    }
+    w.Pdf[0] /= NSpectrumSamples
+}
+
+// SampledSpectrum holds the value of a spectrum at each of the
+// SampledWavelengths of a path.
+type SampledSpectrum [NSpectrumSamples]float64
+
+func MakeConstantSpectrum(c float64) SampledSpectrum {
+    var s SampledSpectrum
+    for i := range s {
+        s[i] = c
+    }
+    return s
+}
+
+func (s *SampledSpectrum) Add(t *SampledSpectrum) *SampledSpectrum {
+    var r SampledSpectrum
+    for i := range s {
+        r[i] = s[i] + t[i]
+    }
+    return &r
+}
+
+func (s *SampledSpectrum) Mul(t *SampledSpectrum) *SampledSpectrum {
+    var r SampledSpectrum
+    for i := range s {
+        r[i] = s[i] * t[i]
+    }
+    return &r
+}
+
+func (s *SampledSpectrum) Scale(t float64) *SampledSpectrum {
+    var r SampledSpectrum
+    for i := range s {
+        r[i] = s[i] * t
+    }
+    return &r
+}
+
+// Convert the radiance carried at the given wavelengths to the working space,
+// integrating against the CIE color matching functions.
+func (s *SampledSpectrum) ToRgb(w *SampledWavelengths) Color {
+    var c Color
+    uniformPdf := 1.0 / (LambdaMax - LambdaMin)
+    for i := range s {
+        if w.Pdf[i] == 0 {
+            continue
+        }
+        weight := WavelengthToRgb(w.Lambda[i])
+        c.Accumulate(weight.Scale(s[i] * uniformPdf / (w.Pdf[i] * NSpectrumSamples)))
+    }
+    return ConvertColor(&c, LinearSrgb, WorkingSpace)
+}
+
+// RgbSigmoid is a smooth spectrum s(l) = sigmoid(C0 t^2 + C1 t + C2), with t
+// the wavelength mapped to [0, 1] over the visible range, fitted to a
+// reflectance linear sRGB color following Jakob and Hanika (2019). Spectra
+// are relative to an equal-energy white, so RGB white is the constant
+// spectrum 1.
+type RgbSigmoid struct {
+    C0, C1, C2 float64
+}
+
+func sigmoid(x float64) float64 {
+    if math.IsInf(x, 0) {
+        if x > 0 {
+            return 1
+        }
+        return 0
+    }
+    return 0.5 + x / (2 * math.Sqrt(1 + x * x))
+}
+
+func normalizedWavelength(lambda float64) float64 {
+    return (lambda - LambdaMin) / (LambdaMax - LambdaMin)
+}
+
+func (s *RgbSigmoid) Value(lambda float64) float64 {
+    t := normalizedWavelength(lambda)
+    return sigmoid((s.C0 * t + s.C1) * t + s.C2)
+}
+
+func (s *RgbSigmoid) Sample(w *SampledWavelengths) SampledSpectrum {
+    var r SampledSpectrum
+    for i := range r {
+        r[i] = s.Value(w.Lambda[i])
+    }
+    return r
+}
+
+// Spectrum of a reflectance in the working space, its RGB components are
+// clamped to [0, 1] in sRGB.
+func RgbAlbedoSpectrum(c *Color, w *SampledWavelengths) SampledSpectrum {
+    srgb := ConvertColor(c, WorkingSpace, LinearSrgb)
+    s := rgbToSigmoid(Clamp(srgb.R, 0, 1), Clamp(srgb.G, 0, 1), Clamp(srgb.B, 0, 1))
+    return s.Sample(w)
+}
+
+// Spectrum of a working space color that can exceed one, like emitted
+// radiance or path weights.
+func RgbUnboundedSpectrum(c *Color, w *SampledWavelengths) SampledSpectrum {
+    srgb := ConvertColor(c, WorkingSpace, LinearSrgb)
+    c = &srgb
+    m := math.Max(c.R, math.Max(c.G, c.B))
+    if m <= 1 {
+        s := rgbToSigmoid(math.Max(c.R, 0), math.Max(c.G, 0), math.Max(c.B, 0))
+        return s.Sample(w)
+    }
+    scale := 2 * m
+    s := rgbToSigmoid(math.Max(c.R, 0) / scale, math.Max(c.G, 0) / scale, math.Max(c.B, 0) / scale)
+    r := s.Sample(w)
+    return *r.Scale(scale)
+}
+
+// Resolution per dimension of the table of fitted sigmoid coefficients.
+const rgbToSpectrumRes = 16
+
+// Step in nanometers of the wavelength grid the sigmoids are fitted on.
+const rgbToSpectrumStep = 5.0
+
+// Table of sigmoid coefficients indexed by the largest RGB component, its
+// value and the two other components relative to it, as in Jakob and Hanika.
+type rgbToSpectrumTable struct {
+    zNodes [rgbToSpectrumRes]float64
+    coeffs [3][rgbToSpectrumRes][rgbToSpectrumRes][rgbToSpectrumRes]RgbSigmoid
+}
+
+var (
+    spectrumTable *rgbToSpectrumTable
+    spectrumTableOnce sync.Once
+)
+
+// Fitting data: normalized wavelengths and the RGB weights at each of them.
+type sigmoidFitter struct {
+    t []float64
+    weights []Color
+    norm Color
+}
+
+func makeSigmoidFitter() *sigmoidFitter {
+    f := &sigmoidFitter{}
+    for lambda := LambdaMin; lambda <= LambdaMax; lambda += rgbToSpectrumStep {
+        w := WavelengthToRgb(lambda)
+        f.t = append(f.t, normalizedWavelength(lambda))
+        f.weights = append(f.weights, w)
+        f.norm.Accumulate(&w)
+    }
+    return f
+}
+
+// RGB color of the sigmoid spectrum and its Jacobian with respect to the
+// three coefficients.
+func (f *sigmoidFitter) eval(c [3]float64) ([3]float64, [3][3]float64) {
+    var rgb [3]float64
+    var jac [3][3]float64
+    norm := [3]float64{f.norm.R, f.norm.G, f.norm.B}
+    for j, t := range f.t {
+        x := (c[0] * t + c[1]) * t + c[2]
+        s := sigmoid(x)
+        ds := 0.5 / math.Pow(1 + x * x, 1.5)
+        w := [3]float64{f.weights[j].R, f.weights[j].G, f.weights[j].B}
+        for k := 0; k < 3; k++ {
+            wk := w[k] / norm[k]
+            rgb[k] += s * wk
+            jac[k][0] += ds * t * t * wk
+            jac[k][1] += ds * t * wk
+            jac[k][2] += ds * wk
+        }
+    }
+    return rgb, jac
+}
+
+// Gauss-Newton fit of the coefficients to the target color, starting from c.
+func (f *sigmoidFitter) fit(target [3]float64, c [3]float64) [3]float64 {
+    for i := 0; i < 30; i++ {
+        rgb, jac := f.eval(c)
+        var residual [3]float64
+        errSq := 0.0
+        for k := 0; k < 3; k++ {
+            residual[k] = target[k] - rgb[k]
+            errSq += residual[k] * residual[k]
+        }
+        if errSq < 1e-12 {
+            break
+        }
+        delta, ok := solve3(jac, residual)
+        if !ok {
+            break
+        }
+        // Limit the step, the sigmoid saturates for targets close to 0 or 1.
+        maxStep := math.Max(math.Abs(delta[0]), math.Max(math.Abs(delta[1]), math.Abs(delta[2])))
+        if maxStep > 50 {
+            for k := range delta {
+                delta[k] *= 50 / maxStep
+            }
+        }
+        for k := range c {
+            c[k] += delta[k]
+        }
+    }
+    return c
+}
+
+// Solve the 3x3 linear system a x = b with Cramer's rule.
+func solve3(a [3][3]float64, b [3]float64) ([3]float64, bool) {
+    det := func(m [3][3]float64) float64 {
+        return m[0][0] * (m[1][1] * m[2][2] - m[1][2] * m[2][1]) -
+            m[0][1] * (m[1][0] * m[2][2] - m[1][2] * m[2][0]) +
+            m[0][2] * (m[1][0] * m[2][1] - m[1][1] * m[2][0])
+    }
+    d := det(a)
+    if math.Abs(d) < 1e-30 || math.IsNaN(d) {
+        return [3]float64{}, false
+    }
+    var x [3]float64
+    for i := 0; i < 3; i++ {
+        m := a
+        for k := 0; k < 3; k++ {
+            m[k][i] = b[k]
+        }
+        x[i] = det(m) / d
+    }
+    return x, true
+}
+
+func smoothstep(x float64) float64 {
+    return x * x * (3 - 2 * x)
+}
+
+func buildSpectrumTable() *rgbToSpectrumTable {
+    table := &rgbToSpectrumTable{}
+    f := makeSigmoidFitter()
+    for i := range table.zNodes {
+        table.zNodes[i] = smoothstep(smoothstep(float64(i) / (rgbToSpectrumRes - 1)))
+    }
+
+    // Fit outwards from a moderate brightness, using each result as the
+    // starting point of its neighbor.
+    start := rgbToSpectrumRes / 5
+    for l := 0; l < 3; l++ {
+        for j := 0; j < rgbToSpectrumRes; j++ {
+            y := float64(j) / (rgbToSpectrumRes - 1)
+            for i := 0; i < rgbToSpectrumRes; i++ {
+                x := float64(i) / (rgbToSpectrumRes - 1)
+                solve := func(k int, c [3]float64) [3]float64 {
+                    z := table.zNodes[k]
+                    var target [3]float64
+                    target[l] = z
+                    target[(l + 1) % 3] = x * z
+                    target[(l + 2) % 3] = y * z
+                    c = f.fit(target, c)
+                    table.coeffs[l][k][j][i] = RgbSigmoid{c[0], c[1], c[2]}
+                    return c
+                }
+
+                first := solve(start, [3]float64{})
+                c := first
+                for k := start + 1; k < rgbToSpectrumRes; k++ {
+                    c = solve(k, c)
+                }
+                c = first
+                for k := start - 1; k >= 0; k-- {
+                    c = solve(k, c)
+                }
+            }
+        }
+    }
+    return table
+}
+
+// Sigmoid spectrum of a reflectance color with components in [0, 1].
+func rgbToSigmoid(r, g, b float64) RgbSigmoid {
+    if r == g && g == b {
+        // Gray is a constant spectrum, invert the sigmoid directly.
+        v := Clamp(r, 1e-9, 1 - 1e-9)
+        return RgbSigmoid{0, 0, (v - 0.5) / math.Sqrt(v * (1 - v))}
+    }
+
+    spectrumTableOnce.Do(func() {
+        spectrumTable = buildSpectrumTable()
+    })
+
+    rgb := [3]float64{r, g, b}
+    m := 0
+    if rgb[1] > rgb[m] {
+        m = 1
+    }
+    if rgb[2] > rgb[m] {
+        m = 2
+    }
+    z := rgb[m]
+    x := rgb[(m + 1) % 3] / z * (rgbToSpectrumRes - 1)
+    y := rgb[(m + 2) % 3] / z * (rgbToSpectrumRes - 1)
+
+    xi := int(math.Min(x, rgbToSpectrumRes - 2))
+    yi := int(math.Min(y, rgbToSpectrumRes - 2))
+    zi := 0
+    for zi < rgbToSpectrumRes - 2 && spectrumTable.zNodes[zi + 1] < z {
+        zi++
+    }
+    dx := x - float64(xi)
+    dy := y - float64(yi)
+    dz := (z - spectrumTable.zNodes[zi]) / (spectrumTable.zNodes[zi + 1] - spectrumTable.zNodes[zi])
+
+    // Trilinear interpolation of the coefficients.
+    var c [3]float64
+    for k := 0; k < 2; k++ {
+        for j := 0; j < 2; j++ {
+            for i := 0; i < 2; i++ {
+                weight := Lerp(1 - dz, dz, float64(k)) * Lerp(1 - dy, dy, float64(j)) * Lerp(1 - dx, dx, float64(i))
+                s := spectrumTable.coeffs[m][zi + k][yi + j][xi + i]
+                c[0] += weight * s.C0
+                c[1] += weight * s.C1
+                c[2] += weight * s.C2
+            }
+        }
+    }
+    return RgbSigmoid{c[0], c[1], c[2]}
+}
+
+// SpectralMaterial is implemented by materials whose scattering depends on
+// the wavelength, so that spectral rendering does not have to go through
+// their RGB approximation.
+type SpectralMaterial interface {
+    ScatterSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool
+}
+
+// SpectralEmitter is implemented by materials that emit a known spectrum.
+type SpectralEmitter interface {
+    EmittedSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths) SampledSpectrum
+}
+
+// Scatter the ray off the material at the given wavelengths. Materials without
+// a spectral implementation have their RGB attenuation upsampled. When one
+// turns out to be wavelength dependent, by restricting the path to a
+// wavelength of its own, it scatters again at the hero wavelength alone.
+func ScatterSpectral(mat Material, rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool {
+    if spectral, ok := mat.(SpectralMaterial); ok {
+        return spectral.ScatterSpectral(rayIn, rec, lambdas, attenuation, scattered)
+    }
+    var c Color
+    if !mat.Scatter(rayIn, rec, &c, scattered) {
+        return false
+    }
+    if rayIn.Wavelength == 0 && scattered.Wavelength > 0 {
+        lambdas.TerminateSecondary()
+        path := *rayIn
+        path.Wavelength = lambdas.Lambda[0]
+        if !mat.Scatter(&path, rec, &c, scattered) {
+            return false
+        }
+    }
+    *attenuation = RgbUnboundedSpectrum(&c, lambdas)
+    return true
+}
+
+// Emitted radiance of the material at the given wavelengths.
+func EmittedSpectral(mat Material, rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths) SampledSpectrum {
+    if spectral, ok := mat.(SpectralEmitter); ok {
+        return spectral.EmittedSpectral(rayIn, rec, lambdas)
+    }
+    return RgbUnboundedSpectrum(mat.Emitted(rayIn, rec), lambdas)
+}
@@ -0,0 +1,123 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// Wavelength range in nanometers over which spectra are integrated.
+const (
+    LambdaMin = 360.0
+    LambdaMax = 830.0
+)
+
+// Piecewise Gaussian used by the CIE matching function fits.
+func gaussianLobe(x, mu, sigma1, sigma2 float64) float64 {
+    sigma := sigma2
+    if x < mu {
+        sigma = sigma1
+    }
+    t := (x - mu) / sigma
+    return math.Exp(-0.5 * t * t)
+}
+
+// CIE 1931 standard observer color matching functions for a wavelength in
+// nanometers, using the multi-lobe fit of Wyman, Sloan and Shirley (2013).
+func CieX(lambda float64) float64 {
+    return 1.056 * gaussianLobe(lambda, 599.8, 37.9, 31.0) +
+        0.362 * gaussianLobe(lambda, 442.0, 16.0, 26.7) -
+        0.065 * gaussianLobe(lambda, 501.1, 20.4, 26.2)
+}
+
+func CieY(lambda float64) float64 {
+    return 0.821 * gaussianLobe(lambda, 568.8, 46.9, 40.5) +
+        0.286 * gaussianLobe(lambda, 530.9, 16.3, 31.1)
+}
+
+func CieZ(lambda float64) float64 {
+    return 1.217 * gaussianLobe(lambda, 437.0, 11.8, 36.0) +
+        0.681 * gaussianLobe(lambda, 459.0, 26.0, 13.8)
+}
+
+// Convert CIE XYZ to linear sRGB (Rec.709 primaries, D65 white point).
+func XyzToLinearSrgb(x, y, z float64) Color {
+    return Color{
+        R: 3.2404542 * x - 1.5371385 * y - 0.4985314 * z,
+        G: -0.9692660 * x + 1.8760108 * y + 0.0415560 * z,
+        B: 0.0556434 * x - 0.2040259 * y + 1.0572252 * z,
+    }
+}
+
+// Spectral radiance of a black body at the given temperature in Kelvin and
+// wavelength in nanometers, in W / (sr m^2 m), following Planck's law.
+func Blackbody(lambda float64, kelvin float64) float64 {
+    if kelvin <= 0 {
+        return 0
+    }
+    const c = 299792458.0
+    const h = 6.62606957e-34
+    const kb = 1.3806488e-23
+    l := lambda * 1e-9
+    return (2 * h * c * c) / (math.Pow(l, 5) * (math.Exp((h * c) / (l * kb * kelvin)) - 1))
+}
+
+// Color of a black body at the given temperature in Kelvin in the working
+// space, normalized to a luminance of one. Colors outside the gamut are
+// clipped.
+func BlackbodyColor(kelvin float64) *Color {
+    xyz := blackbodyXyz(kelvin)
+    if xyz.G <= 0 {
+        return &Color{0, 0, 0}
+    }
+
+    c := WorkingSpace.FromXyz(xyz.R, xyz.G, xyz.B)
+    c = Color{math.Max(c.R, 0), math.Max(c.G, 0), math.Max(c.B, 0)}
+    return c.Scale(1 / c.Luminance())
+}
+
+// CIE XYZ of a black body, normalized to a luminance Y of one.
+func blackbodyXyz(kelvin float64) Color {
+    var x, y, z float64
+    for lambda := LambdaMin; lambda <= LambdaMax; lambda++ {
+        b := Blackbody(lambda, kelvin)
+        x += b * CieX(lambda)
+        y += b * CieY(lambda)
+        z += b * CieZ(lambda)
+    }
+    if y <= 0 {
+        return Color{0, 0, 0}
+    }
+    return Color{x / y, 1, z / y}
+}
+
+// Integral of the linear sRGB color matching functions over the wavelength
+// range, used to normalize single wavelength samples so that a uniformly
+// sampled wavelength averages out to white.
+var wavelengthRgbNorm = integrateWavelengthRgb()
+
+func integrateWavelengthRgb() Color {
+    var sum Color
+    for lambda := LambdaMin; lambda <= LambdaMax; lambda++ {
+        c := XyzToLinearSrgb(CieX(lambda), CieY(lambda), CieZ(lambda))
+        sum.Accumulate(&c)
+    }
+    return sum
+}
+
+// Pick a wavelength in nanometers uniformly over the visible range.
+func SampleWavelength() float64 {
+    return RandInRange(LambdaMin, LambdaMax)
+}
+
+// Weight that converts the radiance carried at a single wavelength, sampled
+// with SampleWavelength, back to linear sRGB. Its expected value over all
+// wavelengths is white; individual weights can be negative for wavelengths
+// outside the sRGB gamut.
+func WavelengthToRgb(lambda float64) Color {
+    c := XyzToLinearSrgb(CieX(lambda), CieY(lambda), CieZ(lambda))
+    n := LambdaMax - LambdaMin + 1
+    return Color{
+        R: c.R * n / wavelengthRgbNorm.R,
+        G: c.G * n / wavelengthRgbNorm.G,
+        B: c.B * n / wavelengthRgbNorm.B,
+    }
+}
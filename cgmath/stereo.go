@@ -0,0 +1,68 @@
+package cgmath
+
+// StereoLayout is how the images of the two eyes share one frame.
+type StereoLayout int
+
+const (
+    // Left eye in the left half, right eye in the right half.
+    SideBySide StereoLayout = iota
+    // Left eye in the top half, right eye in the bottom half.
+    OverUnder
+)
+
+// StereoCamera renders the views of a left and a right eye camera into the
+// two halves of one image.
+type StereoCamera struct {
+    Left, Right Camera
+    Layout StereoLayout
+}
+
+// Stereo pair of perspective cameras, Ipd apart along the transform's U axis.
+// Both eyes look in the same direction with their image centers shifted so
+// objects at the convergence distance appear at the same place in both
+// images, in front of the screen when closer and behind it when further. The
+// aspect ratio is of the image of one eye.
+func MakeStereoCamera(transform CameraTransform, vFov float64, aspectRatio float64, aperture float64, focusDist float64, ipd float64, convergence float64, layout StereoLayout) *StereoCamera {
+    eye := func(side float64) *PerspectiveCamera {
+        t := transform
+        t.Origin = *transform.Origin.Add(transform.U.Scale(side * ipd / 2))
+        c := MakePerspectiveCamera(t, vFov, aspectRatio, aperture, focusDist)
+        c.shiftX = -side * ipd / (2 * convergence)
+        return c
+    }
+    return &StereoCamera{Left: eye(-1), Right: eye(1), Layout: layout}
+}
+
+// Omni-directional stereo camera: a pair of equirectangular cameras whose
+// eyes circle the origin, Ipd apart, so every column of the panorama is seen
+// in stereo when viewed in a headset. Over-under is the usual layout.
+func MakeOdsCamera(transform CameraTransform, ipd float64, layout StereoLayout) *StereoCamera {
+    left := MakeEquirectangularCamera(transform)
+    left.EyeOffset = -ipd / 2
+    right := MakeEquirectangularCamera(transform)
+    right.EyeOffset = ipd / 2
+    return &StereoCamera{Left: left, Right: right, Layout: layout}
+}
+
+func (c *StereoCamera) SetImageSize(width int, height int) {
+    if c.Layout == OverUnder {
+        height /= 2
+    } else {
+        width /= 2
+    }
+    c.Left.SetImageSize(width, height)
+    c.Right.SetImageSize(width, height)
+}
+
+func (c *StereoCamera) MakeRay(u, v float64) (Ray, bool) {
+    if c.Layout == OverUnder {
+        if v >= 0.5 {
+            return c.Left.MakeRay(u, 2 * v - 1)
+        }
+        return c.Right.MakeRay(u, 2 * v)
+    }
+    if u < 0.5 {
+        return c.Left.MakeRay(2 * u, v)
+    }
+    return c.Right.MakeRay(2 * u - 1, v)
+}
@@ -0,0 +1,254 @@
+package cgmath
+
+import (
+    "fmt"
+    "math"
+)
+
+// Maximum number of scattering events of a random walk before the path is
+// considered absorbed.
+const maxSubsurfaceSteps = 256
+
+// Offset used to avoid self intersection when the walk hits the boundary.
+const subsurfaceEpsilon = 0.0001
+
+// SubsurfaceMaterial is a smooth dielectric boundary filled with a homogeneous
+// medium that scatters light isotropically, for translucent materials like
+// skin, wax and marble. Light that refracts into the surface does a random
+// walk through the interior until it leaves the boundary again.
+//
+// The random walk needs to trace against the enclosing geometry, so the
+// material only scatters once MakeSubsurface attaches it to a closed
+// boundary.
+type SubsurfaceMaterial struct {
+    RefractiveIndex float64
+    // Absorption and scattering coefficients per unit length.
+    SigmaA, SigmaS Color
+}
+
+func MakeSubsurfaceMaterial(refractiveIndex float64, sigmaA Color, sigmaS Color) *SubsurfaceMaterial {
+    return &SubsurfaceMaterial{
+        RefractiveIndex: refractiveIndex,
+        SigmaA: sigmaA,
+        SigmaS: sigmaS,
+    }
+}
+
+// Subsurface material with the given overall albedo, the color the surface
+// shows after many scattering events, and the mean free path, the average
+// distance light travels in the medium before it scatters or is absorbed.
+func MakeSubsurfaceMaterialFromAlbedo(refractiveIndex float64, albedo Color, meanFreePath Color) *SubsurfaceMaterial {
+    sigma := func(albedo float64, meanFreePath float64) (float64, float64) {
+        sigmaT := 1.0 / meanFreePath
+        sigmaS := singleScatteringAlbedo(albedo) * sigmaT
+        return sigmaT - sigmaS, sigmaS
+    }
+
+    var sigmaA, sigmaS Color
+    sigmaA.R, sigmaS.R = sigma(albedo.R, meanFreePath.R)
+    sigmaA.G, sigmaS.G = sigma(albedo.G, meanFreePath.G)
+    sigmaA.B, sigmaS.B = sigma(albedo.B, meanFreePath.B)
+    return MakeSubsurfaceMaterial(refractiveIndex, sigmaA, sigmaS)
+}
+
+// Invert the multiple scattering albedo of a random walk into the albedo of a
+// single scattering event, with the fit used by Cycles.
+func singleScatteringAlbedo(albedo float64) float64 {
+    a := Clamp(albedo, 0.0, 0.999)
+    s := 4.09712 + 4.20863 * a - math.Sqrt(9.59217 + 41.6808 * a + 17.7126 * a * a)
+    return 1.0 - s * s
+}
+
+func channel(c *Color, i int) float64 {
+    switch i {
+    case 0:
+        return c.R
+    case 1:
+        return c.G
+    }
+    return c.B
+}
+
+func expColor(c *Color, t float64) Color {
+    return Color{math.Exp(-c.R * t), math.Exp(-c.G * t), math.Exp(-c.B * t)}
+}
+
+// The material of a Subsurface, a SubsurfaceMaterial bound to its boundary.
+type subsurfaceScatter struct {
+    SubsurfaceMaterial
+    boundary Hittable
+}
+
+func (mat *subsurfaceScatter) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    *attenuation = Color{1.0, 1.0, 1.0}
+    unitDirection := rayIn.Dir.UnitVector()
+
+    // Rays that start inside, e.g. from a camera in the volume, simply leave,
+    // or reflect back in like at any other exit.
+    if !rec.FrontFace {
+        cosTheta := math.Min(-unitDirection.Dot(&rec.Normal), 1.0)
+        sinTheta := math.Sqrt(1.0 - cosTheta * cosTheta)
+        cannotRefract := mat.RefractiveIndex * sinTheta > 1.0
+        if cannotRefract || reflectance(cosTheta, mat.RefractiveIndex) > Rand() {
+            *scattered = rayIn.Spawn(&rec.P, Reflect(unitDirection, &rec.Normal))
+            return true
+        }
+        *scattered = rayIn.Spawn(&rec.P, Refract(unitDirection, &rec.Normal, mat.RefractiveIndex))
+        return true
+    }
+
+    refractionRatio := 1.0 / mat.RefractiveIndex
+    cosTheta := math.Min(-unitDirection.Dot(&rec.Normal), 1.0)
+    if reflectance(cosTheta, refractionRatio) > Rand() {
+        *scattered = rayIn.Spawn(&rec.P, Reflect(unitDirection, &rec.Normal))
+        return true
+    }
+
+    direction := Refract(unitDirection, &rec.Normal, refractionRatio).UnitVector()
+    return mat.randomWalk(rayIn, rec, direction, attenuation, scattered)
+}
+
+// Follow the path through the interior until it refracts out of the
+// boundary, in the space the boundary was hit in. The free flight distance is
+// sampled from one randomly chosen color channel and weighted by the average
+// pdf over all channels.
+func (mat *subsurfaceScatter) randomWalk(rayIn *Ray, entry *HitRecord, direction *Vec3, throughput *Color, scattered *Ray) bool {
+    frame := entry.subsurface
+    if frame == nil {
+        frame = &identitySubsurfaceFrame
+    }
+    sigmaT := mat.SigmaA.Add(&mat.SigmaS)
+    position := frame.point(&entry.P)
+    direction = frame.direction(direction)
+
+    for i := 0; i < maxSubsurfaceSteps; i++ {
+        distance := math.Inf(1)
+        if st := channel(sigmaT, RandInt(0, 3)); st > 0 {
+            distance = -math.Log(1.0 - Rand()) / st
+        }
+
+        var rec HitRecord
+        walk := rayIn.Spawn(position, direction)
+        if mat.boundary.Hit(&walk, subsurfaceEpsilon, distance, &rec) {
+            // A scattering event within the offset of the boundary can slip
+            // out through it, drop those paths instead of entering again.
+            if rec.FrontFace {
+                return false
+            }
+
+            // Reached the boundary before scattering.
+            transmittance := expColor(sigmaT, rec.T)
+            pdf := (transmittance.R + transmittance.G + transmittance.B) / 3.0
+            *throughput = *throughput.Mul(transmittance.Scale(1.0 / pdf))
+
+            cosTheta := math.Min(-direction.Dot(&rec.Normal), 1.0)
+            sinTheta := math.Sqrt(1.0 - cosTheta * cosTheta)
+            cannotRefract := mat.RefractiveIndex * sinTheta > 1.0
+            if cannotRefract || reflectance(cosTheta, mat.RefractiveIndex) > Rand() {
+                direction = Reflect(direction, &rec.Normal)
+                position = &rec.P
+                continue
+            }
+
+            exit := Refract(direction, &rec.Normal, mat.RefractiveIndex)
+            *scattered = rayIn.Spawn(frame.worldPoint(&rec.P), frame.worldDirection(exit))
+            return true
+        }
+
+        // Scatter inside the medium.
+        transmittance := expColor(sigmaT, distance)
+        density := sigmaT.Mul(&transmittance)
+        pdf := (density.R + density.G + density.B) / 3.0
+        *throughput = *throughput.Mul(mat.SigmaS.Mul(&transmittance).Scale(1.0 / pdf))
+
+        position = walk.At(distance)
+        direction = RandomUnitVector()
+    }
+
+    return false
+}
+
+func (mat *subsurfaceScatter) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    return &Color{0, 0, 0}
+}
+
+// Subsurface binds a SubsurfaceMaterial to the closed boundary that encloses
+// its medium. It can be moved with Translate and RotateY like any other
+// object.
+type Subsurface struct {
+    boundary Hittable
+    material subsurfaceScatter
+}
+
+func MakeSubsurface(boundary Hittable, material *SubsurfaceMaterial) *Subsurface {
+    return &Subsurface{
+        boundary: boundary,
+        material: subsurfaceScatter{SubsurfaceMaterial: *material, boundary: boundary},
+    }
+}
+
+func (s *Subsurface) Hit(r *Ray, tMin float64, tMax float64, rec *HitRecord) bool {
+    if !s.boundary.Hit(r, tMin, tMax, rec) {
+        return false
+    }
+    rec.Material = &s.material
+    rec.subsurface = &identitySubsurfaceFrame
+    return true
+}
+
+func (s *Subsurface) BoundingBox(time0 float64, time1 float64, outputBox *Aabb) bool {
+    return s.boundary.BoundingBox(time0, time1, outputBox)
+}
+
+func (s *Subsurface) String() string {
+    return fmt.Sprintf("Subsurface(boundary=%v)", s.boundary)
+}
+
+// subsurfaceFrame is the rigid transform from world space to the space a
+// Subsurface was hit in. Translate and RotateY add their transforms to it on
+// the way out of the hit, so that the random walk traces the boundary in its
+// own space.
+type subsurfaceFrame struct {
+    // Rows of the rotation, applied before adding the offset.
+    rows [3]Vec3
+    offset Vec3
+}
+
+var identitySubsurfaceFrame = subsurfaceFrame{rows: [3]Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}
+
+func (f *subsurfaceFrame) direction(d *Vec3) *Vec3 {
+    return &Vec3{f.rows[0].Dot(d), f.rows[1].Dot(d), f.rows[2].Dot(d)}
+}
+
+func (f *subsurfaceFrame) point(p *Vec3) *Vec3 {
+    return f.direction(p).Add(&f.offset)
+}
+
+// The inverse rotation is the transpose.
+func (f *subsurfaceFrame) worldDirection(d *Vec3) *Vec3 {
+    return f.rows[0].Scale(d.X).Add(f.rows[1].Scale(d.Y)).Add(f.rows[2].Scale(d.Z))
+}
+
+func (f *subsurfaceFrame) worldPoint(p *Vec3) *Vec3 {
+    return f.worldDirection(p.Sub(&f.offset))
+}
+
+// The frame after a transform that takes world space points p to rows * p +
+// offset, the space the frame starts from. Frames are shared between hit
+// records, so a new one is returned.
+func (f *subsurfaceFrame) after(rows [3]Vec3, offset *Vec3) *subsurfaceFrame {
+    g := &subsurfaceFrame{offset: *f.point(offset)}
+    for i := range g.rows {
+        r := &f.rows[i]
+        g.rows[i] = *rows[0].Scale(r.X).Add(rows[1].Scale(r.Y)).Add(rows[2].Scale(r.Z))
+    }
+    return g
+}
+
+// Add a transform of the rays passed on to a child to the frame of a
+// Subsurface hit, for Translate and RotateY.
+func (h *HitRecord) transformSubsurface(rows [3]Vec3, offset *Vec3) {
+    if _, ok := h.Material.(*subsurfaceScatter); ok && h.subsurface != nil {
+        h.subsurface = h.subsurface.after(rows, offset)
+    }
+}
@@ -0,0 +1,56 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+func TestSubsurfaceTransformed(t *testing.T) {
+    material := MakeSubsurfaceMaterialFromAlbedo(1.3, Color{0.8, 0.6, 0.4}, Color{0.2, 0.1, 0.05})
+    sphere := func() Hittable {
+        return MakeSubsurface(&Sphere{Center: Vec3{0, 0, 0}, Radius: 1}, material)
+    }
+    tests := []struct {
+        name string
+        object Hittable
+        center Vec3
+    }{
+        {"untransformed", sphere(), Vec3{0, 0, 0}},
+        {"translated", MakeTranslate(sphere(), Vec3{5, 1, -2}), Vec3{5, 1, -2}},
+        // RotateY by 90 degrees takes x to -z.
+        {"rotated", MakeRotateY(MakeTranslate(sphere(), Vec3{3, 0, 0}), 90), Vec3{0, 0, -3}},
+    }
+    for _, tt := range tests {
+        exits := 0
+        for i := 0; i < 1000; i++ {
+            // Rays from above at the top of the sphere.
+            offset := RandomInUnitDisk().Scale(0.5)
+            orig := tt.center.Add(&Vec3{offset.X, 5, offset.Y})
+            r := Ray{Orig: *orig, Dir: Vec3{0, -1, 0}}
+            var rec HitRecord
+            if !tt.object.Hit(&r, 0.001, math.Inf(1), &rec) {
+                t.Fatalf("%s: ray %v misses", tt.name, r)
+            }
+
+            var attenuation Color
+            var scattered Ray
+            if !rec.Material.Scatter(&r, &rec, &attenuation, &scattered) {
+                continue
+            }
+            // Paths reflected at the entry leave where they hit.
+            if scattered.Orig.Sub(&rec.P).Length() > 1e-6 {
+                exits++
+            }
+            radial := scattered.Orig.Sub(&tt.center)
+            if math.Abs(radial.Length() - 1) > 1e-3 {
+                t.Fatalf("%s: path leaves at %v, %g from the center", tt.name, scattered.Orig, radial.Length())
+            }
+            if radial.Dot(&scattered.Dir) <= 0 {
+                t.Fatalf("%s: path leaves at %v into the sphere", tt.name, scattered.Orig)
+            }
+        }
+        if exits < 100 {
+            t.Errorf("%s: %d of 1000 paths walk through the sphere", tt.name, exits)
+        }
+    }
+}
@@ -1,17 +1,37 @@
 package cgmath
 
 import (
+    "fmt"
     "math"
     "image"
     _ "image/jpeg"
-    "log"
+    _ "image/png"
     "os"
+    "path/filepath"
+    "strings"
+    "sync"
 )
 
 type Texture interface {
     Value(u float64, v float64, p *Vec3) Color
 }
 
+// HitTexture is implemented by textures that need more of the hit than its
+// UV coordinates and position, like the ray footprint for filtering.
+type HitTexture interface {
+    Texture
+    ValueAtHit(rec *HitRecord) Color
+}
+
+// Look up the texture at a hit, using the whole hit record for textures that
+// support it. Other textures get the object space position of the hit.
+func TextureValue(t Texture, rec *HitRecord) Color {
+    if ht, ok := t.(HitTexture); ok {
+        return ht.ValueAtHit(rec)
+    }
+    return t.Value(rec.U, rec.V, &rec.ObjectP)
+}
+
 type SolidColor struct {
     color Color
 }
@@ -24,58 +44,425 @@ func (s* SolidColor) Value(u float64, v float64, p *Vec3) Color {
     return s.color
 }
 
+// The coordinates a procedural texture is evaluated in.
+type TextureSpace int
+
+const (
+    // The 3D point passed to the texture, like a solid block of material.
+    SolidSpace TextureSpace = iota
+    // The surface UV coordinates, as (u, v, 0).
+    UvSpace
+)
+
+func texturePoint(space TextureSpace, u float64, v float64, p *Vec3) Vec3 {
+    if space == UvSpace {
+        return Vec3{u, v, 0}
+    }
+    return *p
+}
+
+// CheckerTexture alternates between two textures. In solid space the
+// checkers are the sign of the sines of the position at frequency Scale, in
+// UV space there are Scale squares along each of u and v.
 type CheckerTexture struct {
-    odd, even Texture
+    Odd, Even Texture
+    Scale float64
+    Space TextureSpace
 }
 
 func MakeCheckerTexture(odd, even Texture) *CheckerTexture {
-    return &CheckerTexture{odd: odd, even: even}
+    return &CheckerTexture{Odd: odd, Even: even, Scale: 10, Space: SolidSpace}
 }
 
 func (t *CheckerTexture) Value(u float64, v float64, p *Vec3) Color {
-    sines := math.Sin(10 * p.X) * math.Sin(10 * p.Y) * math.Sin(10 * p.Z)
-    if sines < 0 {
-        return t.odd.Value(u, v, p)
+    var odd bool
+    if t.Space == UvSpace {
+        cells := int(math.Floor(u * t.Scale)) + int(math.Floor(v * t.Scale))
+        odd = cells & 1 == 1
     } else {
-        return t.even.Value(u, v, p)        
+        sines := math.Sin(t.Scale * p.X) * math.Sin(t.Scale * p.Y) * math.Sin(t.Scale * p.Z)
+        odd = sines < 0
+    }
+    if odd {
+        return t.Odd.Value(u, v, p)
+    } else {
+        return t.Even.Value(u, v, p)
     }
 }
 
-type ImageTexture struct {
-    image image.Image
+type TextureFilter int
+
+const (
+    NearestFilter TextureFilter = iota
+    BilinearFilter
+    BicubicFilter
+)
+
+// How texel coordinates outside of the image are mapped back into it.
+type WrapMode int
+
+const (
+    RepeatWrap WrapMode = iota
+    ClampWrap
+    MirrorWrap
+)
+
+// How an ImageTexture filters over the footprint of a ray, selecting from a
+// pyramid of prefiltered, downsampled copies of the image.
+type MipFilter int
+
+const (
+    NoMipFilter MipFilter = iota
+    TrilinearMipFilter
+    EwaMipFilter
+)
+
+// Longest allowed ratio between the axes of the EWA filter ellipse.
+const maxAnisotropy = 8.0
+
+// One level of an image pyramid, stored row by row from the top.
+type imageLevel struct {
+    pixels []Color
     width int
     height int
 }
 
-func MakeImageTexture(imagePath string) *ImageTexture {
+// Largest image the decoders allocate, so that a corrupt header fails instead
+// of exhausting memory.
+const maxImagePixels = 1 << 28
+
+func checkImageSize(width int, height int) error {
+    if width <= 0 || height <= 0 {
+        return fmt.Errorf("bad image size %dx%d", width, height)
+    }
+    if width > maxImagePixels / height {
+        return fmt.Errorf("image size %dx%d is too large", width, height)
+    }
+    return nil
+}
+
+// ImageTexture is an image decoded once into a buffer of linear float colors.
+type ImageTexture struct {
+    image imageLevel
+    Filter TextureFilter
+    WrapU, WrapV WrapMode
+    MipFilter MipFilter
+    pyramid []imageLevel
+    pyramidOnce sync.Once
+}
+
+// Load an image as a texture. The encoding tells how its values relate to
+// linear light: color maps are usually sRGB encoded, while data like normal,
+// bump or opacity maps should be loaded raw. Radiance (.hdr) and OpenEXR
+// (.exr) images hold linear floating point values and are never sRGB
+// decoded, other formats like JPEG and PNG are read as 8 or 16 bit images.
+func MakeImageTexture(imagePath string, encoding ColorEncoding) (*ImageTexture, error) {
     reader, err := os.Open(imagePath)
     if err != nil {
-        log.Fatal(err)
+        return nil, err
     }
     defer reader.Close()
 
-    image, _, err := image.Decode(reader)
-    bounds := image.Bounds()
+    var level imageLevel
+    switch strings.ToLower(filepath.Ext(imagePath)) {
+    case ".hdr", ".pic":
+        level, err = decodeRadiance(reader)
+        if encoding == SrgbEncoding {
+            encoding = LinearEncoding
+        }
+    case ".exr":
+        level, err = decodeExr(reader)
+        if encoding == SrgbEncoding {
+            encoding = LinearEncoding
+        }
+    default:
+        var img image.Image
+        img, _, err = image.Decode(reader)
+        if err == nil {
+            return MakeImageTextureFromImage(img, encoding), nil
+        }
+    }
+    if err != nil {
+        return nil, fmt.Errorf("decoding %s: %w", imagePath, err)
+    }
+    return makeImageTextureFromLevel(level, encoding), nil
+}
+
+// Texture of an image, decoded into the working space.
+func MakeImageTextureFromImage(img image.Image, encoding ColorEncoding) *ImageTexture {
+    bounds := img.Bounds()
+    width := bounds.Max.X - bounds.Min.X
+    height := bounds.Max.Y - bounds.Min.Y
+
+    pixels := make([]Color, width * height)
+    for j := 0; j < height; j++ {
+        for i := 0; i < width; i++ {
+            r, g, b, _ := img.At(bounds.Min.X + i, bounds.Min.Y + j).RGBA()
+            pixels[j * width + i] = Color{
+                R: float64(r) / 0xffff,
+                G: float64(g) / 0xffff,
+                B: float64(b) / 0xffff,
+            }
+        }
+    }
+    return makeImageTextureFromLevel(imageLevel{pixels: pixels, width: width, height: height}, encoding)
+}
+
+// Texture of float pixels with sRGB primaries, stored row by row from the
+// top, decoded into the working space.
+func MakeImageTextureFromPixels(pixels []Color, width int, height int, encoding ColorEncoding) (*ImageTexture, error) {
+    if width <= 0 || height <= 0 || len(pixels) != width * height {
+        return nil, fmt.Errorf("%d pixels do not fill a %dx%d image", len(pixels), width, height)
+    }
+    level := imageLevel{pixels: append([]Color(nil), pixels...), width: width, height: height}
+    return makeImageTextureFromLevel(level, encoding), nil
+}
+
+func makeImageTextureFromLevel(level imageLevel, encoding ColorEncoding) *ImageTexture {
+    for i := range level.pixels {
+        c := decode(&level.pixels[i], encoding)
+        if encoding != RawEncoding {
+            c = ConvertColor(&c, LinearSrgb, WorkingSpace)
+        }
+        level.pixels[i] = c
+    }
 
     return &ImageTexture{
-        image: image,
-        width: bounds.Max.X - bounds.Min.X,
-        height: bounds.Max.Y - bounds.Min.Y,
+        image: level,
+        Filter: BilinearFilter,
+        WrapU: ClampWrap,
+        WrapV: ClampWrap,
+        MipFilter: TrilinearMipFilter,
+    }
+}
+
+func wrap(i int, n int, mode WrapMode) int {
+    switch mode {
+    case ClampWrap:
+        if i < 0 {
+            return 0
+        }
+        if i >= n {
+            return n - 1
+        }
+        return i
+    case MirrorWrap:
+        period := 2 * n
+        i = ((i % period) + period) % period
+        if i >= n {
+            i = period - 1 - i
+        }
+        return i
     }
+    return ((i % n) + n) % n
+}
+
+func (l *imageLevel) texel(i int, j int, wrapU WrapMode, wrapV WrapMode) *Color {
+    i = wrap(i, l.width, wrapU)
+    j = wrap(j, l.height, wrapV)
+    return &l.pixels[j * l.width + i]
+}
+
+// Catmull-Rom weights of the four texels around a sample at fraction f past
+// the second one.
+func cubicWeights(f float64) [4]float64 {
+    f2 := f * f
+    f3 := f2 * f
+    return [4]float64{
+        0.5 * (-f3 + 2 * f2 - f),
+        0.5 * (3 * f3 - 5 * f2 + 2),
+        0.5 * (-3 * f3 + 4 * f2 + f),
+        0.5 * (f3 - f2),
+    }
+}
+
+// Filtered lookup at the texel space coordinates x and y, where texel
+// centers are at half integers.
+func (l *imageLevel) lookup(x float64, y float64, filter TextureFilter, wrapU WrapMode, wrapV WrapMode) Color {
+    switch filter {
+    case NearestFilter:
+        return *l.texel(int(math.Floor(x)), int(math.Floor(y)), wrapU, wrapV)
+    case BicubicFilter:
+        x -= 0.5
+        y -= 0.5
+        i := int(math.Floor(x))
+        j := int(math.Floor(y))
+        wx := cubicWeights(x - float64(i))
+        wy := cubicWeights(y - float64(j))
+        var c Color
+        for n := 0; n < 4; n++ {
+            for m := 0; m < 4; m++ {
+                c.Accumulate(l.texel(i - 1 + m, j - 1 + n, wrapU, wrapV).Scale(wx[m] * wy[n]))
+            }
+        }
+        return c
+    }
+
+    x -= 0.5
+    y -= 0.5
+    i := int(math.Floor(x))
+    j := int(math.Floor(y))
+    fx := x - float64(i)
+    fy := y - float64(j)
+    top := l.texel(i, j, wrapU, wrapV).Lerp(l.texel(i + 1, j, wrapU, wrapV), fx)
+    bottom := l.texel(i, j + 1, wrapU, wrapV).Lerp(l.texel(i + 1, j + 1, wrapU, wrapV), fx)
+    return *top.Lerp(bottom, fy)
+}
+
+// Half resolution copy of the level, averaging 2x2 blocks of texels.
+func (l *imageLevel) downsample(wrapU WrapMode, wrapV WrapMode) imageLevel {
+    width := int(math.Max(1, float64(l.width / 2)))
+    height := int(math.Max(1, float64(l.height / 2)))
+    pixels := make([]Color, width * height)
+    for j := 0; j < height; j++ {
+        for i := 0; i < width; i++ {
+            var c Color
+            c.Accumulate(l.texel(2 * i, 2 * j, wrapU, wrapV))
+            c.Accumulate(l.texel(2 * i + 1, 2 * j, wrapU, wrapV))
+            c.Accumulate(l.texel(2 * i, 2 * j + 1, wrapU, wrapV))
+            c.Accumulate(l.texel(2 * i + 1, 2 * j + 1, wrapU, wrapV))
+            pixels[j * width + i] = *c.Scale(0.25)
+        }
+    }
+    return imageLevel{pixels: pixels, width: width, height: height}
+}
+
+// The image pyramid, built on first use.
+func (t *ImageTexture) mipLevels() []imageLevel {
+    t.pyramidOnce.Do(func() {
+        t.pyramid = []imageLevel{t.image}
+        for {
+            last := &t.pyramid[len(t.pyramid) - 1]
+            if last.width == 1 && last.height == 1 {
+                break
+            }
+            t.pyramid = append(t.pyramid, last.downsample(t.WrapU, t.WrapV))
+        }
+    })
+    return t.pyramid
 }
 
 func (t *ImageTexture) Value(u float64, v float64, p *Vec3) Color {
-    u = Clamp(u, 0.0, 1.0)
-    v = 1.0 - Clamp(v, 0.0, 1.0)
+    if len(t.image.pixels) == 0 {
+        return Color{0, 1, 1}
+    }
+    // v runs bottom to top.
+    x := u * float64(t.image.width)
+    y := (1.0 - v) * float64(t.image.height)
+    return t.image.lookup(x, y, t.Filter, t.WrapU, t.WrapV)
+}
 
-    i := int(u * float64(t.width))
-    j := int(v * float64(t.height))
+// Look up the texture filtered over the footprint of the ray at the hit.
+func (t *ImageTexture) ValueAtHit(rec *HitRecord) Color {
+    if t.MipFilter == NoMipFilter || !rec.HasDifferentials || len(t.image.pixels) == 0 {
+        return t.Value(rec.U, rec.V, &rec.ObjectP)
+    }
+
+    levels := t.mipLevels()
+    if t.MipFilter == EwaMipFilter {
+        return t.ewa(levels, rec)
+    }
 
-    r, g, b, _ := t.image.At(i, j).RGBA()
+    // Level where the footprint is about one texel wide.
+    w := float64(t.image.width)
+    h := float64(t.image.height)
+    width := math.Max(math.Max(math.Abs(rec.Dudx) * w, math.Abs(rec.Dvdx) * h),
+        math.Max(math.Abs(rec.Dudy) * w, math.Abs(rec.Dvdy) * h))
+    level := Clamp(math.Log2(math.Max(width, 1e-8)), 0, float64(len(levels) - 1))
+    if level == 0 {
+        return t.Value(rec.U, rec.V, &rec.ObjectP)
+    }
 
-    return Color{
-        R: float64(r) / 0xffff,
-        G: float64(g) / 0xffff,
-        B: float64(b) / 0xffff,
+    lower := int(math.Floor(level))
+    c0 := t.levelLookup(&levels[lower], rec.U, rec.V)
+    if lower == len(levels) - 1 {
+        return c0
+    }
+    c1 := t.levelLookup(&levels[lower + 1], rec.U, rec.V)
+    return *c0.Lerp(&c1, level - float64(lower))
+}
+
+func (t *ImageTexture) levelLookup(l *imageLevel, u float64, v float64) Color {
+    return l.lookup(u * float64(l.width), (1.0 - v) * float64(l.height), BilinearFilter, t.WrapU, t.WrapV)
+}
+
+// Elliptically weighted average filtering, following Heckbert's EWA filter
+// with a Gaussian weight as in pbrt.
+func (t *ImageTexture) ewa(levels []imageLevel, rec *HitRecord) Color {
+    dst0 := [2]float64{rec.Dudx, -rec.Dvdx}
+    dst1 := [2]float64{rec.Dudy, -rec.Dvdy}
+    len0 := math.Hypot(dst0[0], dst0[1])
+    len1 := math.Hypot(dst1[0], dst1[1])
+    if len0 < len1 {
+        dst0, dst1 = dst1, dst0
+        len0, len1 = len1, len0
+    }
+    if len1 == 0 {
+        return t.Value(rec.U, rec.V, &rec.ObjectP)
+    }
+
+    // Widen overly eccentric ellipses to bound the number of texels read.
+    if len1 * maxAnisotropy < len0 {
+        scale := len0 / (len1 * maxAnisotropy)
+        dst1[0] *= scale
+        dst1[1] *= scale
+        len1 *= scale
+    }
+
+    size := math.Max(float64(t.image.width), float64(t.image.height))
+    level := Clamp(math.Log2(math.Max(len1 * size, 1e-8)), 0, float64(len(levels) - 1))
+    lower := int(math.Floor(level))
+    c0 := t.ewaLevel(&levels[lower], rec.U, rec.V, dst0, dst1)
+    if lower == len(levels) - 1 {
+        return c0
+    }
+    c1 := t.ewaLevel(&levels[lower + 1], rec.U, rec.V, dst0, dst1)
+    return *c0.Lerp(&c1, level - float64(lower))
+}
+
+func (t *ImageTexture) ewaLevel(l *imageLevel, u float64, v float64, dst0 [2]float64, dst1 [2]float64) Color {
+    // Ellipse in texel space of this level.
+    w := float64(l.width)
+    h := float64(l.height)
+    s := u * w - 0.5
+    tt := (1.0 - v) * h - 0.5
+    du0, dv0 := dst0[0] * w, dst0[1] * h
+    du1, dv1 := dst1[0] * w, dst1[1] * h
+
+    a := dv0 * dv0 + dv1 * dv1 + 1
+    b := -2 * (du0 * dv0 + du1 * dv1)
+    c := du0 * du0 + du1 * du1 + 1
+    invF := 1 / (a * c - b * b * 0.25)
+    a *= invF
+    b *= invF
+    c *= invF
+
+    det := -b * b + 4 * a * c
+    invDet := 1 / det
+    uSqrt := math.Sqrt(det * c)
+    vSqrt := math.Sqrt(a * det)
+    s0 := int(math.Ceil(s - 2 * invDet * uSqrt))
+    s1 := int(math.Floor(s + 2 * invDet * uSqrt))
+    t0 := int(math.Ceil(tt - 2 * invDet * vSqrt))
+    t1 := int(math.Floor(tt + 2 * invDet * vSqrt))
+
+    const alpha = 2.0
+    var sum Color
+    weightSum := 0.0
+    for it := t0; it <= t1; it++ {
+        dt := float64(it) - tt
+        for is := s0; is <= s1; is++ {
+            ds := float64(is) - s
+            r2 := a * ds * ds + b * ds * dt + c * dt * dt
+            if r2 < 1 {
+                weight := math.Exp(-alpha * r2) - math.Exp(-alpha)
+                sum.Accumulate(l.texel(is, it, t.WrapU, t.WrapV).Scale(weight))
+                weightSum += weight
+            }
+        }
+    }
+    if weightSum <= 0 {
+        return t.levelLookup(l, u, v)
     }
+    return *sum.Scale(1 / weightSum)
 }
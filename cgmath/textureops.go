@@ -0,0 +1,285 @@
+package cgmath
+
+import (
+    "math"
+    "sort"
+)
+
+// Operator textures combine the values of other textures. Each is written
+// once against a lookup function, so that it works both for plain lookups
+// and for lookups at a hit, where inputs like an ImageTexture need the ray
+// footprint.
+type textureLookup func(t Texture) Color
+
+func pointLookup(u float64, v float64, p *Vec3) textureLookup {
+    return func(t Texture) Color {
+        return t.Value(u, v, p)
+    }
+}
+
+func hitLookup(rec *HitRecord) textureLookup {
+    return func(t Texture) Color {
+        return TextureValue(t, rec)
+    }
+}
+
+// ScaleTexture multiplies a texture by a constant color.
+type ScaleTexture struct {
+    Input Texture
+    Factor Color
+}
+
+func MakeScaleTexture(input Texture, factor float64) *ScaleTexture {
+    return &ScaleTexture{Input: input, Factor: Color{factor, factor, factor}}
+}
+
+func (t *ScaleTexture) eval(lookup textureLookup) Color {
+    c := lookup(t.Input)
+    return *c.Mul(&t.Factor)
+}
+
+func (t *ScaleTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *ScaleTexture) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+type AddTexture struct {
+    A, B Texture
+}
+
+func (t *AddTexture) eval(lookup textureLookup) Color {
+    a := lookup(t.A)
+    b := lookup(t.B)
+    return *a.Add(&b)
+}
+
+func (t *AddTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *AddTexture) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+type MultiplyTexture struct {
+    A, B Texture
+}
+
+func (t *MultiplyTexture) eval(lookup textureLookup) Color {
+    a := lookup(t.A)
+    b := lookup(t.B)
+    return *a.Mul(&b)
+}
+
+func (t *MultiplyTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *MultiplyTexture) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+// MixTexture blends A and B per channel by Amount: 0 gives A, 1 gives B.
+type MixTexture struct {
+    A, B Texture
+    Amount Texture
+}
+
+func (t *MixTexture) eval(lookup textureLookup) Color {
+    a := lookup(t.A)
+    b := lookup(t.B)
+    m := lookup(t.Amount)
+    return Color{Lerp(a.R, b.R, m.R), Lerp(a.G, b.G, m.G), Lerp(a.B, b.B, m.B)}
+}
+
+func (t *MixTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *MixTexture) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+// InvertTexture is one minus its input.
+type InvertTexture struct {
+    Input Texture
+}
+
+func (t *InvertTexture) eval(lookup textureLookup) Color {
+    c := lookup(t.Input)
+    return Color{1 - c.R, 1 - c.G, 1 - c.B}
+}
+
+func (t *InvertTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *InvertTexture) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+// ClampTexture limits every channel of its input to [Min, Max].
+type ClampTexture struct {
+    Input Texture
+    Min, Max float64
+}
+
+func (t *ClampTexture) eval(lookup textureLookup) Color {
+    c := lookup(t.Input)
+    return Color{Clamp(c.R, t.Min, t.Max), Clamp(c.G, t.Min, t.Max), Clamp(c.B, t.Min, t.Max)}
+}
+
+func (t *ClampTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *ClampTexture) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+type RampInterpolation int
+
+const (
+    LinearRamp RampInterpolation = iota
+    // Hold the color of a stop until the next one.
+    ConstantRamp
+    SmoothRamp
+)
+
+type RampStop struct {
+    Position float64
+    Color Color
+}
+
+// ColorRamp remaps the luminance of its input to a gradient through color
+// stops. Below the first and above the last stop the end colors hold.
+type ColorRamp struct {
+    Input Texture
+    Stops []RampStop
+    Interpolation RampInterpolation
+}
+
+func MakeColorRamp(input Texture, stops []RampStop) *ColorRamp {
+    sorted := append([]RampStop(nil), stops...)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i].Position < sorted[j].Position
+    })
+    return &ColorRamp{Input: input, Stops: sorted, Interpolation: LinearRamp}
+}
+
+func (t *ColorRamp) At(x float64) Color {
+    n := len(t.Stops)
+    if n == 0 {
+        return Color{x, x, x}
+    }
+    i := sort.Search(n, func(i int) bool {
+        return t.Stops[i].Position > x
+    })
+    if i == 0 {
+        return t.Stops[0].Color
+    }
+    if i == n {
+        return t.Stops[n - 1].Color
+    }
+
+    a, b := &t.Stops[i - 1], &t.Stops[i]
+    f := (x - a.Position) / (b.Position - a.Position)
+    switch t.Interpolation {
+        case ConstantRamp:
+            f = 0
+        case SmoothRamp:
+            f = f * f * (3 - 2 * f)
+    }
+    return *a.Color.Lerp(&b.Color, f)
+}
+
+func (t *ColorRamp) eval(lookup textureLookup) Color {
+    c := lookup(t.Input)
+    return t.At(c.Luminance())
+}
+
+func (t *ColorRamp) Value(u float64, v float64, p *Vec3) Color {
+    return t.eval(pointLookup(u, v, p))
+}
+
+func (t *ColorRamp) ValueAtHit(rec *HitRecord) Color {
+    return t.eval(hitLookup(rec))
+}
+
+// UvTransformTexture looks up its input at transformed UV coordinates: tiled
+// by Scale, rotated by Rotation degrees around the origin, then offset.
+type UvTransformTexture struct {
+    Input Texture
+    ScaleU, ScaleV float64
+    Rotation float64
+    OffsetU, OffsetV float64
+}
+
+func MakeUvTransformTexture(input Texture) *UvTransformTexture {
+    return &UvTransformTexture{Input: input, ScaleU: 1, ScaleV: 1}
+}
+
+// Apply the linear part of the transform to a UV vector.
+func (t *UvTransformTexture) linear(u float64, v float64) (float64, float64) {
+    sin, cos := math.Sincos(DegToRad(t.Rotation))
+    u *= t.ScaleU
+    v *= t.ScaleV
+    return cos * u - sin * v, sin * u + cos * v
+}
+
+func (t *UvTransformTexture) Value(u float64, v float64, p *Vec3) Color {
+    tu, tv := t.linear(u, v)
+    return t.Input.Value(tu + t.OffsetU, tv + t.OffsetV, p)
+}
+
+func (t *UvTransformTexture) ValueAtHit(rec *HitRecord) Color {
+    tr := *rec
+    tu, tv := t.linear(rec.U, rec.V)
+    tr.U, tr.V = tu + t.OffsetU, tv + t.OffsetV
+    // The footprint scales with the tiling.
+    tr.Dudx, tr.Dvdx = t.linear(rec.Dudx, rec.Dvdx)
+    tr.Dudy, tr.Dvdy = t.linear(rec.Dudy, rec.Dvdy)
+    return TextureValue(t.Input, &tr)
+}
+
+// PointTransformTexture looks up its input at a transformed 3D point: scaled,
+// rotated by Rotation degrees around the x, y and z axes in that order, then
+// offset.
+type PointTransformTexture struct {
+    Input Texture
+    Scale Vec3
+    Rotation Vec3
+    Offset Vec3
+}
+
+func MakePointTransformTexture(input Texture) *PointTransformTexture {
+    return &PointTransformTexture{Input: input, Scale: Vec3{1, 1, 1}}
+}
+
+func (t *PointTransformTexture) linear(p *Vec3) Vec3 {
+    sx, cx := math.Sincos(DegToRad(t.Rotation.X))
+    sy, cy := math.Sincos(DegToRad(t.Rotation.Y))
+    sz, cz := math.Sincos(DegToRad(t.Rotation.Z))
+
+    q := *p.Mul(&t.Scale)
+    q = Vec3{q.X, cx * q.Y - sx * q.Z, sx * q.Y + cx * q.Z}
+    q = Vec3{cy * q.X + sy * q.Z, q.Y, -sy * q.X + cy * q.Z}
+    return Vec3{cz * q.X - sz * q.Y, sz * q.X + cz * q.Y, q.Z}
+}
+
+func (t *PointTransformTexture) Value(u float64, v float64, p *Vec3) Color {
+    q := t.linear(p)
+    return t.Input.Value(u, v, q.Add(&t.Offset))
+}
+
+func (t *PointTransformTexture) ValueAtHit(rec *HitRecord) Color {
+    tr := *rec
+    q := t.linear(&rec.ObjectP)
+    tr.ObjectP = *q.Add(&t.Offset)
+    tr.Dpdx = t.linear(&rec.Dpdx)
+    tr.Dpdy = t.linear(&rec.Dpdy)
+    return TextureValue(t.Input, &tr)
+}
@@ -0,0 +1,53 @@
+package cgmath
+
+import (
+    "math"
+    "testing"
+)
+
+func TestColorRampAt(t *testing.T) {
+    // Stops given out of order, MakeColorRamp sorts them.
+    stops := []RampStop{
+        {Position: 0.75, Color: Color{0, 0, 1}},
+        {Position: 0.25, Color: Color{1, 0, 0}},
+        {Position: 0.5, Color: Color{0, 1, 0}},
+    }
+    tests := []struct {
+        name string
+        interpolation RampInterpolation
+        x float64
+        want Color
+    }{
+        {"below first stop", LinearRamp, -1, Color{1, 0, 0}},
+        {"at first stop", LinearRamp, 0.25, Color{1, 0, 0}},
+        {"at middle stop", LinearRamp, 0.5, Color{0, 1, 0}},
+        {"at last stop", LinearRamp, 0.75, Color{0, 0, 1}},
+        {"above last stop", LinearRamp, 2, Color{0, 0, 1}},
+        {"linear between", LinearRamp, 0.3125, Color{0.75, 0.25, 0}},
+        {"linear halfway", LinearRamp, 0.625, Color{0, 0.5, 0.5}},
+        {"constant between", ConstantRamp, 0.7, Color{0, 1, 0}},
+        {"constant at stop", ConstantRamp, 0.75, Color{0, 0, 1}},
+        {"smooth between", SmoothRamp, 0.3125, Color{0.84375, 0.15625, 0}},
+        {"smooth halfway", SmoothRamp, 0.625, Color{0, 0.5, 0.5}},
+    }
+    for _, tt := range tests {
+        ramp := MakeColorRamp(MakeSolidColor(0, 0, 0), stops)
+        ramp.Interpolation = tt.interpolation
+        c := ramp.At(tt.x)
+        if math.Abs(c.R - tt.want.R) > 1e-9 || math.Abs(c.G - tt.want.G) > 1e-9 || math.Abs(c.B - tt.want.B) > 1e-9 {
+            t.Errorf("%s: At(%g) = %v, want %v", tt.name, tt.x, c, tt.want)
+        }
+    }
+
+    // Without stops the ramp is a grey gradient.
+    empty := ColorRamp{}
+    if c := empty.At(0.3); c != (Color{0.3, 0.3, 0.3}) {
+        t.Errorf("empty ramp At(0.3) = %v", c)
+    }
+
+    // The input is looked up by its luminance.
+    ramp := MakeColorRamp(MakeSolidColor(0.5, 0.5, 0.5), stops)
+    if c := ramp.Value(0, 0, &Vec3{}); math.Abs(c.G - 1) > 1e-6 {
+        t.Errorf("ramp of grey input = %v, want the middle stop", c)
+    }
+}
@@ -0,0 +1,229 @@
+package cgmath
+
+import (
+    "math"
+    "math/cmplx"
+)
+
+// Dominant wavelengths in nanometers of the sRGB red, green and blue
+// primaries, used to evaluate wavelength dependent effects per channel.
+var rgbWavelengths = [3]float64{612.0, 549.0, 465.0}
+
+// Fresnel amplitude coefficients for s and p polarized light crossing from a
+// medium with index n1 into one with index n2. Indices may be complex for
+// conductors. Also returns the cosine of the transmitted angle.
+func fresnelAmplitudes(n1, n2, cos1 complex128) (complex128, complex128, complex128) {
+    sin1Sq := 1 - cos1 * cos1
+    ratio := n1 / n2
+    cos2 := cmplx.Sqrt(1 - ratio * ratio * sin1Sq)
+    rs := (n1 * cos1 - n2 * cos2) / (n1 * cos1 + n2 * cos2)
+    rp := (n2 * cos1 - n1 * cos2) / (n2 * cos1 + n1 * cos2)
+    return rs, rp, cos2
+}
+
+// Reflectance of a thin film of the given thickness and index, on top of a
+// substrate with a possibly complex index, for unpolarized light of the given
+// wavelength. Thickness and wavelength are both in nanometers. The multiple
+// reflections inside the film are summed with the Airy formula.
+func ThinFilmReflectance(cosTheta float64, lambda float64, thickness float64, outsideIor float64, filmIor float64, substrateIor complex128) float64 {
+    n1 := complex(outsideIor, 0)
+    n2 := complex(filmIor, 0)
+    rs12, rp12, cos2 := fresnelAmplitudes(n1, n2, complex(cosTheta, 0))
+    rs23, rp23, _ := fresnelAmplitudes(n2, substrateIor, cos2)
+
+    // Phase difference between successive reflections inside the film.
+    delta := 4 * math.Pi * n2 * complex(thickness, 0) * cos2 / complex(lambda, 0)
+    phase := cmplx.Exp(1i * delta)
+
+    rs := (rs12 + rs23 * phase) / (1 + rs12 * rs23 * phase)
+    rp := (rp12 + rp23 * phase) / (1 + rp12 * rp23 * phase)
+    absRs := cmplx.Abs(rs)
+    absRp := cmplx.Abs(rp)
+    return Clamp(0.5 * (absRs * absRs + absRp * absRp), 0.0, 1.0)
+}
+
+// Complex index of refraction of a conductor with the given normal-incidence
+// reflectivity and edge tint, following Gulbrandsen (2014).
+func conductorIor(reflectivity float64, edgeTint float64) complex128 {
+    r := Clamp(reflectivity, 0.0, 0.99)
+    g := Clamp(edgeTint, 0.0, 1.0)
+    sqrtR := math.Sqrt(r)
+    n := g * (1 - r) / (1 + r) + (1 - g) * (1 + sqrtR) / (1 - sqrtR)
+    k := math.Sqrt(math.Max(((n + 1) * (n + 1) * r - (n - 1) * (n - 1)) / (1 - r), 0))
+    return complex(n, k)
+}
+
+// ThinFilm covers Base with a thin transparent film, like a soap bubble, an
+// oil slick or an anti-reflective coating on a lens. Interference between the
+// reflections off both sides of the film gives wavelength dependent colors.
+//
+// A Dielectric base is the substrate the film sits on and a Metal base is
+// treated as a conductor with the Metal's albedo as its reflectivity. Any other
+// base sees the light that passes through the film, on top of a substrate
+// with index SubstrateIor.
+type ThinFilm struct {
+    Base Material
+    // Film thickness in nanometers, multiplied by the luminance of
+    // ThicknessMap if one is given.
+    Thickness float64
+    ThicknessMap Texture
+    FilmIor float64
+    SubstrateIor float64
+}
+
+func MakeThinFilm(base Material, thickness float64, filmIor float64) *ThinFilm {
+    return &ThinFilm{
+        Base: base,
+        Thickness: thickness,
+        FilmIor: filmIor,
+        SubstrateIor: 1.5,
+    }
+}
+
+func (mat *ThinFilm) thickness(rec *HitRecord) float64 {
+    if mat.ThicknessMap == nil {
+        return mat.Thickness
+    }
+    c := TextureValue(mat.ThicknessMap, rec)
+    return mat.Thickness * c.Luminance()
+}
+
+// Index outside the film and the complex index of the substrate below it.
+// The two are swapped when the light arrives from inside a dielectric. Metals
+// use the given reflectivity.
+func (mat *ThinFilm) indices(rec *HitRecord, metalReflectivity float64) (float64, complex128) {
+    switch base := mat.Base.(type) {
+    case *Dielectric:
+        if rec.FrontFace {
+            return 1.0, complex(base.RefractiveIndex, 0)
+        }
+        return base.RefractiveIndex, 1
+    case *Metal:
+        return 1.0, conductorIor(metalReflectivity, metalReflectivity)
+    }
+    return 1.0, complex(mat.SubstrateIor, 0)
+}
+
+// Reflectance of the film per color channel for light arriving with the given
+// cosine to the normal. Paths restricted to a single wavelength evaluate all
+// channels at that wavelength.
+func (mat *ThinFilm) reflectance(rayIn *Ray, rec *HitRecord, cosTheta float64) Color {
+    lambdas := rgbWavelengths
+    if rayIn.Wavelength > 0 {
+        lambdas = [3]float64{rayIn.Wavelength, rayIn.Wavelength, rayIn.Wavelength}
+    }
+    var albedo Color
+    if metal, ok := mat.Base.(*Metal); ok {
+        albedo = metal.Albedo
+    }
+
+    d := mat.thickness(rec)
+    var r [3]float64
+    for i := range r {
+        outside, substrate := mat.indices(rec, channel(&albedo, i))
+        r[i] = ThinFilmReflectance(cosTheta, lambdas[i], d, outside, mat.FilmIor, substrate)
+    }
+    return Color{r[0], r[1], r[2]}
+}
+
+func (mat *ThinFilm) Scatter(rayIn *Ray, rec *HitRecord, attenuation *Color, scattered *Ray) bool {
+    unitDirection := rayIn.Dir.UnitVector()
+    cosTheta := math.Min(-unitDirection.Dot(&rec.Normal), 1.0)
+    r := mat.reflectance(rayIn, rec, cosTheta)
+
+    if metal, ok := mat.Base.(*Metal); ok {
+        // Light transmitted into a conductor is absorbed.
+        fuzz := math.Min(metal.Fuzz, 1.0)
+        reflected := Reflect(unitDirection, &rec.Normal).Add(RandomInUnitSphere().Scale(fuzz))
+        *scattered = rayIn.Spawn(&rec.P, reflected)
+        *attenuation = r
+        return scattered.Dir.Dot(&rec.Normal) > 0
+    }
+
+    // Choose between reflection and transmission proportional to the average
+    // reflectance and weight the result per channel.
+    p := (r.R + r.G + r.B) / 3.0
+    if Rand() < p {
+        *scattered = rayIn.Spawn(&rec.P, Reflect(unitDirection, &rec.Normal))
+        *attenuation = *r.Scale(1.0 / p)
+        return true
+    }
+    transmitted := Color{1 - r.R, 1 - r.G, 1 - r.B}
+    transmitted = *transmitted.Scale(1.0 / (1.0 - p))
+
+    if dielectric, ok := mat.Base.(*Dielectric); ok {
+        refractionRatio := dielectric.RefractiveIndex
+        if rec.FrontFace {
+            refractionRatio = 1.0 / dielectric.RefractiveIndex
+        }
+        *scattered = rayIn.Spawn(&rec.P, Refract(unitDirection, &rec.Normal, refractionRatio))
+        *attenuation = transmitted
+        return true
+    }
+
+    if !mat.Base.Scatter(rayIn, rec, attenuation, scattered) {
+        return false
+    }
+    *attenuation = *attenuation.Mul(&transmitted)
+    return true
+}
+
+func (mat *ThinFilm) Emitted(rayIn *Ray, rec *HitRecord) *Color {
+    return mat.Base.Emitted(rayIn, rec)
+}
+
+func (mat *ThinFilm) ScatterSpectral(rayIn *Ray, rec *HitRecord, lambdas *SampledWavelengths, attenuation *SampledSpectrum, scattered *Ray) bool {
+    unitDirection := rayIn.Dir.UnitVector()
+    cosTheta := math.Min(-unitDirection.Dot(&rec.Normal), 1.0)
+
+    var albedo SampledSpectrum
+    metal, isMetal := mat.Base.(*Metal)
+    if isMetal {
+        albedo = RgbAlbedoSpectrum(&metal.Albedo, lambdas)
+    }
+
+    d := mat.thickness(rec)
+    var r SampledSpectrum
+    for i := range r {
+        outside, substrate := mat.indices(rec, albedo[i])
+        r[i] = ThinFilmReflectance(cosTheta, lambdas.Lambda[i], d, outside, mat.FilmIor, substrate)
+    }
+
+    if isMetal {
+        fuzz := math.Min(metal.Fuzz, 1.0)
+        reflected := Reflect(unitDirection, &rec.Normal).Add(RandomInUnitSphere().Scale(fuzz))
+        *scattered = rayIn.Spawn(&rec.P, reflected)
+        *attenuation = r
+        return scattered.Dir.Dot(&rec.Normal) > 0
+    }
+
+    p := 0.0
+    for i := range r {
+        p += r[i] / NSpectrumSamples
+    }
+    if Rand() < p {
+        *scattered = rayIn.Spawn(&rec.P, Reflect(unitDirection, &rec.Normal))
+        *attenuation = *r.Scale(1.0 / p)
+        return true
+    }
+    var transmitted SampledSpectrum
+    for i := range r {
+        transmitted[i] = (1 - r[i]) / (1 - p)
+    }
+
+    if dielectric, ok := mat.Base.(*Dielectric); ok {
+        refractionRatio := dielectric.RefractiveIndex
+        if rec.FrontFace {
+            refractionRatio = 1.0 / dielectric.RefractiveIndex
+        }
+        *scattered = rayIn.Spawn(&rec.P, Refract(unitDirection, &rec.Normal, refractionRatio))
+        *attenuation = transmitted
+        return true
+    }
+
+    if !ScatterSpectral(mat.Base, rayIn, rec, lambdas, attenuation, scattered) {
+        return false
+    }
+    *attenuation = *attenuation.Mul(&transmitted)
+    return true
+}
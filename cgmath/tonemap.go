@@ -0,0 +1,230 @@
+package cgmath
+
+import (
+    "fmt"
+    "io"
+    "math"
+)
+
+// ToneMapper compresses the HDR range of a linear color in the given space,
+// the output space of the pipeline, into the displayable range.
+type ToneMapper interface {
+    Map(c *Color, space *ColorSpace) Color
+}
+
+// ClampToneMapper clips every channel to [0, 1].
+type ClampToneMapper struct{}
+
+func (t *ClampToneMapper) Map(c *Color, space *ColorSpace) Color {
+    return Color{Clamp(c.R, 0, 1), Clamp(c.G, 0, 1), Clamp(c.B, 0, 1)}
+}
+
+// ReinhardToneMapper is the extended Reinhard operator on luminance, which
+// maps WhitePoint to one. A zero white point gives the basic L / (1 + L).
+type ReinhardToneMapper struct {
+    WhitePoint float64
+}
+
+func (t *ReinhardToneMapper) Map(c *Color, space *ColorSpace) Color {
+    l := space.Luminance(c)
+    if l <= 0 {
+        return Color{0, 0, 0}
+    }
+    mapped := l / (1 + l)
+    if t.WhitePoint > 0 {
+        mapped = l * (1 + l / (t.WhitePoint * t.WhitePoint)) / (1 + l)
+    }
+    return *c.Scale(mapped / l)
+}
+
+// HableToneMapper is the filmic curve John Hable made for Uncharted 2.
+type HableToneMapper struct {
+    ExposureBias float64
+    WhitePoint float64
+}
+
+func MakeHableToneMapper() *HableToneMapper {
+    return &HableToneMapper{ExposureBias: 2.0, WhitePoint: 11.2}
+}
+
+func hableCurve(x float64) float64 {
+    const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+    return ((x * (a * x + c * b) + d * e) / (x * (a * x + b) + d * f)) - e / f
+}
+
+func (t *HableToneMapper) Map(c *Color, space *ColorSpace) Color {
+    whiteScale := 1.0 / hableCurve(t.WhitePoint)
+    curve := func(x float64) float64 {
+        return Clamp(hableCurve(math.Max(x, 0) * t.ExposureBias) * whiteScale, 0, 1)
+    }
+    return Color{curve(c.R), curve(c.G), curve(c.B)}
+}
+
+// AcesFittedToneMapper is Stephen Hill's fit of the ACES reference rendering
+// and sRGB output transforms. The fit takes and gives linear sRGB, colors in
+// other spaces are converted to it and back.
+type AcesFittedToneMapper struct{}
+
+var acesInput = Mat3{
+    {0.59719, 0.35458, 0.04823},
+    {0.07600, 0.90834, 0.01566},
+    {0.02840, 0.13383, 0.83777},
+}
+
+var acesOutput = Mat3{
+    {1.60475, -0.53108, -0.07367},
+    {-0.10208, 1.10813, -0.00605},
+    {-0.00327, -0.07276, 1.07602},
+}
+
+func (t *AcesFittedToneMapper) Map(c *Color, space *ColorSpace) Color {
+    srgb := ConvertColor(c, space, LinearSrgb)
+    v := acesInput.MulColor(&srgb)
+    fit := func(x float64) float64 {
+        a := x * (x + 0.0245786) - 0.000090537
+        b := x * (0.983729 * x + 0.4329510) + 0.238081
+        return a / b
+    }
+    v = Color{fit(v.R), fit(v.G), fit(v.B)}
+    v = acesOutput.MulColor(&v)
+    v = Color{Clamp(v.R, 0, 1), Clamp(v.G, 0, 1), Clamp(v.B, 0, 1)}
+    return ConvertColor(&v, LinearSrgb, space)
+}
+
+// AgxToneMapper is Troy Sobotka's AgX with its default look, which
+// desaturates bright colors towards white instead of skewing their hue. Like
+// the ACES fit it works in linear sRGB.
+type AgxToneMapper struct{}
+
+var agxInset = Mat3{
+    {0.842479062253094, 0.0784335999999992, 0.0792237451477643},
+    {0.0423282422610123, 0.878468636469772, 0.0791661274605434},
+    {0.0423756549057051, 0.0784336, 0.879142973793104},
+}
+
+var agxOutset = Mat3{
+    {1.19687900512017, -0.0980208811401368, -0.0990297440797205},
+    {-0.0528968517574562, 1.15190312990417, -0.0989611768448433},
+    {-0.0529716355144438, -0.0980434501171241, 1.15107367264116},
+}
+
+func (t *AgxToneMapper) Map(c *Color, space *ColorSpace) Color {
+    const minEv = -12.47393
+    const maxEv = 4.026069
+
+    srgb := ConvertColor(c, space, LinearSrgb)
+    v := agxInset.MulColor(&srgb)
+    curve := func(x float64) float64 {
+        // Log2 encoding followed by a polynomial fit of the AgX sigmoid.
+        x = (Clamp(math.Log2(math.Max(x, 1e-10)), minEv, maxEv) - minEv) / (maxEv - minEv)
+        x2 := x * x
+        x4 := x2 * x2
+        return 15.5 * x4 * x2 - 40.14 * x4 * x + 31.96 * x4 - 6.868 * x2 * x + 0.4298 * x2 + 0.1191 * x - 0.00232
+    }
+    v = Color{curve(v.R), curve(v.G), curve(v.B)}
+    v = agxOutset.MulColor(&v)
+
+    // The curve output is display encoded, return it to linear light.
+    linear := func(x float64) float64 {
+        return math.Pow(Clamp(x, 0, 1), 2.2)
+    }
+    v = Color{linear(v.R), linear(v.G), linear(v.B)}
+    return ConvertColor(&v, LinearSrgb, space)
+}
+
+// Bradford cone response, used for chromatic adaptation.
+var bradford = Mat3{
+    {0.8951, 0.2664, -0.1614},
+    {-0.7502, 1.7135, 0.0367},
+    {0.0389, -0.0685, 1.0296},
+}
+
+var d65White = Color{0.95047, 1.0, 1.08883}
+
+// Chromatic adaptation in XYZ from the given white to D65, so that a surface
+// lit by a light of that white appears neutral.
+func whiteBalance(white *Color) Mat3 {
+    src := bradford.MulColor(white)
+    dst := bradford.MulColor(&d65White)
+    scale := Mat3{
+        {dst.R / src.R, 0, 0},
+        {0, dst.G / src.G, 0},
+        {0, 0, dst.B / src.B},
+    }
+    inverse := bradford.Inverse()
+    m := scale.Mul(&bradford)
+    return inverse.Mul(&m)
+}
+
+// OutputPipeline turns the HDR working space radiance of a render into
+// display values: exposure, white balance, tone mapping and finally the
+// output transform.
+type OutputPipeline struct {
+    // Exposure adjustment in stops.
+    Exposure float64
+    // Color temperature in Kelvin that is balanced to white, zero to leave
+    // colors as they are.
+    WhiteBalance float64
+    ToneMapper ToneMapper
+    Output OutputTransform
+}
+
+func MakeOutputPipeline() *OutputPipeline {
+    return &OutputPipeline{
+        ToneMapper: &ClampToneMapper{},
+        Output: SrgbOutput,
+    }
+}
+
+func (p *OutputPipeline) Apply(c *Color) Color {
+    exposed := *c.Scale(math.Pow(2, p.Exposure))
+
+    if p.WhiteBalance > 0 {
+        white := blackbodyXyz(p.WhiteBalance)
+        adapt := whiteBalance(&white)
+        xyz := WorkingSpace.ToXyz(&exposed)
+        xyz = adapt.MulColor(&xyz)
+        exposed = WorkingSpace.FromXyz(xyz.R, xyz.G, xyz.B)
+    }
+
+    linear := ConvertColor(&exposed, WorkingSpace, p.Output.Space)
+    mapped := p.ToneMapper.Map(&linear, p.Output.Space)
+    return encode(&mapped, p.Output.Encoding)
+}
+
+// Framebuffer holds the HDR working space radiance of a render, row by row
+// from the top.
+type Framebuffer struct {
+    Width, Height int
+    Pixels []Color
+}
+
+func MakeFramebuffer(width int, height int) *Framebuffer {
+    return &Framebuffer{
+        Width: width,
+        Height: height,
+        Pixels: make([]Color, width * height),
+    }
+}
+
+func (f *Framebuffer) At(i int, j int) *Color {
+    return &f.Pixels[j * f.Width + i]
+}
+
+func (f *Framebuffer) Set(i int, j int, c *Color) {
+    f.Pixels[j * f.Width + i] = *c
+}
+
+// Write the framebuffer through the output pipeline as a plain PPM image.
+func (f *Framebuffer) WritePpm(w io.Writer, pipeline *OutputPipeline) {
+    fmt.Fprintf(w, "P3\n")
+    fmt.Fprintf(w, "%d %d\n", f.Width, f.Height)
+    fmt.Fprintf(w, "255\n")
+    for _, c := range f.Pixels {
+        out := pipeline.Apply(&c)
+        fmt.Fprintf(w, "%d %d %d\n",
+            int(256 * Clamp(out.R, 0.0, 0.999)),
+            int(256 * Clamp(out.G, 0.0, 0.999)),
+            int(256 * Clamp(out.B, 0.0, 0.999)))
+    }
+}
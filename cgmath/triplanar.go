@@ -0,0 +1,92 @@
+package cgmath
+
+import (
+    "math"
+)
+
+// TriplanarTexture projects a texture along each of the object space axes
+// and blends the three by how much the surface faces each axis, which maps
+// textures onto any shape without UV seams or stretching.
+type TriplanarTexture struct {
+    // Textures projected along x, onto the (z, y) plane, along y, onto
+    // (x, z), and along z, onto (x, y).
+    X, Y, Z Texture
+    // Repeats per unit of object space.
+    Scale float64
+    // Exponent on the normal weights, higher values narrow the blend
+    // between projections.
+    Sharpness float64
+}
+
+func MakeTriplanarTexture(t Texture, scale float64) *TriplanarTexture {
+    return &TriplanarTexture{X: t, Y: t, Z: t, Scale: scale, Sharpness: 4}
+}
+
+func (t *TriplanarTexture) weights(n *Vec3) Vec3 {
+    w := Vec3{
+        math.Pow(math.Abs(n.X), t.Sharpness),
+        math.Pow(math.Abs(n.Y), t.Sharpness),
+        math.Pow(math.Abs(n.Z), t.Sharpness),
+    }
+    sum := w.X + w.Y + w.Z
+    if sum <= 0 {
+        return Vec3{1.0 / 3, 1.0 / 3, 1.0 / 3}
+    }
+    return *w.Div(sum)
+}
+
+// Without a normal the three projections are weighted equally.
+func (t *TriplanarTexture) Value(u float64, v float64, p *Vec3) Color {
+    s := p.Scale(t.Scale)
+    cx := t.X.Value(s.Z, s.Y, p)
+    cy := t.Y.Value(s.X, s.Z, p)
+    cz := t.Z.Value(s.X, s.Y, p)
+    sum := cx.Add(&cy).Add(&cz)
+    return *sum.Scale(1.0 / 3)
+}
+
+// Look up a single projection, with the UV footprint taken from the position
+// differentials along the same axes.
+func (t *TriplanarTexture) project(tex Texture, rec *HitRecord, axis func(p *Vec3) (float64, float64)) Color {
+    tr := *rec
+    tr.U, tr.V = axis(rec.ObjectP.Scale(t.Scale))
+    tr.Dudx, tr.Dvdx = axis(rec.Dpdx.Scale(t.Scale))
+    tr.Dudy, tr.Dvdy = axis(rec.Dpdy.Scale(t.Scale))
+    return TextureValue(tex, &tr)
+}
+
+func (t *TriplanarTexture) ValueAtHit(rec *HitRecord) Color {
+    w := t.weights(&rec.ObjectNormal)
+    var c Color
+    if w.X > 0 {
+        cx := t.project(t.X, rec, func(p *Vec3) (float64, float64) { return p.Z, p.Y })
+        c.Accumulate(cx.Scale(w.X))
+    }
+    if w.Y > 0 {
+        cy := t.project(t.Y, rec, func(p *Vec3) (float64, float64) { return p.X, p.Z })
+        c.Accumulate(cy.Scale(w.Y))
+    }
+    if w.Z > 0 {
+        cz := t.project(t.Z, rec, func(p *Vec3) (float64, float64) { return p.X, p.Y })
+        c.Accumulate(cz.Scale(w.Z))
+    }
+    return c
+}
+
+// WorldSpaceTexture looks up its input with the world space position and
+// normal in place of the object space ones, for textures that should stay
+// fixed in the scene while objects move through them.
+type WorldSpaceTexture struct {
+    Input Texture
+}
+
+func (t *WorldSpaceTexture) Value(u float64, v float64, p *Vec3) Color {
+    return t.Input.Value(u, v, p)
+}
+
+func (t *WorldSpaceTexture) ValueAtHit(rec *HitRecord) Color {
+    tr := *rec
+    tr.ObjectP = rec.P
+    tr.ObjectNormal = *rec.outwardNormal()
+    return TextureValue(t.Input, &tr)
+}
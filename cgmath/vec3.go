@@ -99,6 +99,33 @@ func RandomInHemisphere(normal *Vec3) *Vec3 {
     return inUnitSphere.Negate()
 }
 
+// Uniformly distributed unit vector within the cone around the unit vector
+// axis whose half angle has the cosine cosMax.
+func RandomInCone(axis *Vec3, cosMax float64) *Vec3 {
+    cosTheta := 1 - Rand() * (1 - cosMax)
+    sinTheta := math.Sqrt(math.Max(0, 1 - cosTheta * cosTheta))
+    phi := 2 * math.Pi * Rand()
+    u, v := orthonormalBasis(axis)
+    d := u.Scale(math.Cos(phi) * sinTheta).Add(v.Scale(math.Sin(phi) * sinTheta))
+    return d.Add(axis.Scale(cosTheta))
+}
+
+// Density per solid angle of RandomInCone.
+func ConePdf(cosMax float64) float64 {
+    return 1 / (2 * math.Pi * (1 - cosMax))
+}
+
+// Two unit vectors that form an orthonormal basis with the unit vector w.
+func orthonormalBasis(w *Vec3) (*Vec3, *Vec3) {
+    a := &Vec3{1, 0, 0}
+    if math.Abs(w.X) > 0.9 {
+        a = &Vec3{0, 1, 0}
+    }
+    v := w.Cross(a).UnitVector()
+    u := v.Cross(w)
+    return u, v
+}
+
 func (v *Vec3) NearZero() bool {
     s := 1e-8
     return math.Abs(v.X) < s && math.Abs(v.Y) < s && math.Abs(v.Z) < s
@@ -3,6 +3,7 @@ package main
 import (
     cgm "raytracer/cgmath"
     "fmt"
+    "log"
     "math"
     "os"
     "time"
@@ -95,7 +96,10 @@ func twoPerlinSpheres() *cgm.HittableList {
 }
 
 func earth() *cgm.HittableList {
-    earthTexture := cgm.MakeImageTexture("earthmap.jpg")
+    earthTexture, err := cgm.MakeImageTexture("earthmap.jpg", cgm.SrgbEncoding)
+    if err != nil {
+        log.Fatal(err)
+    }
     earthSuface := cgm.Lambertian{earthTexture}
     objects := &cgm.HittableList{}
     objects.Add(&cgm.Sphere{cgm.Vec3{0, 0, 0}, 2, &earthSuface})
@@ -108,7 +112,7 @@ func simpleLight() *cgm.HittableList {
     objects.Add(&cgm.Sphere{cgm.Vec3{0, -1000, 0}, 1000, &cgm.Lambertian{perlinTexture}})
     objects.Add(&cgm.Sphere{cgm.Vec3{0, 2, 0}, 2, &cgm.Lambertian{perlinTexture}})
 
-    diffLight := cgm.DiffuseLight{cgm.MakeSolidColor(4, 4, 4)}
+    diffLight := cgm.DiffuseLight{Emit: cgm.MakeSolidColor(4, 4, 4)}
     objects.Add(&cgm.XyRect{3, 5, 1, 3, -2, &diffLight})
     return objects
 }
@@ -117,7 +121,7 @@ func cornellBox() *cgm.HittableList {
     red := &cgm.Lambertian{Albedo: cgm.MakeSolidColor(0.65, 0.05, 0.05)}
     white := &cgm.Lambertian{Albedo: cgm.MakeSolidColor(0.73, 0.73, 0.73)}
     green := &cgm.Lambertian{Albedo: cgm.MakeSolidColor(0.12, 0.45, 0.15)}
-    light := &cgm.DiffuseLight{cgm.MakeSolidColor(15, 15, 15)}
+    light := &cgm.DiffuseLight{Emit: cgm.MakeSolidColor(15, 15, 15)}
 
     objects := &cgm.HittableList{}
     objects.Add(&cgm.YzRect{0, 555, 0, 555, 555, green})
@@ -137,25 +141,146 @@ func cornellBox() *cgm.HittableList {
     return objects
 }
 
-func rayColor(r *cgm.Ray, background *cgm.Color, world cgm.Hittable, depth int) *cgm.Color {
+func outdoorScene() *cgm.HittableList {
+    ground := &cgm.Lambertian{Albedo: cgm.MakeSolidColor(0.5, 0.5, 0.5)}
+    white := &cgm.Lambertian{Albedo: cgm.MakeSolidColor(0.73, 0.73, 0.73)}
+    objects := &cgm.HittableList{}
+    objects.Add(&cgm.Sphere{cgm.Vec3{0, -1000, 0}, 1000, ground})
+    objects.Add(&cgm.Sphere{cgm.Vec3{0, 1, 0}, 1, white})
+    objects.Add(&cgm.Sphere{cgm.Vec3{-2.5, 1, 0}, 1, &cgm.Metal{Albedo: cgm.Color{0.8, 0.6, 0.2}, Fuzz: 0}})
+    objects.Add(&cgm.Sphere{cgm.Vec3{2.5, 1, 0}, 1, &cgm.Dielectric{1.5}})
+    return objects
+}
+
+// Weight of a sample from one of two sampling strategies, by the power
+// heuristic for multiple importance sampling.
+func powerHeuristic(pdf float64, otherPdf float64) float64 {
+    a := pdf * pdf
+    b := otherPdf * otherPdf
+    if a + b == 0 {
+        return 0
+    }
+    return a / (a + b)
+}
+
+// Whether anything blocks the segment from p along dir up to the distance.
+func occluded(r *cgm.Ray, p *cgm.Vec3, dir *cgm.Vec3, distance float64, world cgm.Hittable) bool {
+    shadow := r.Spawn(p, dir)
+    var occluder cgm.HitRecord
+    return world.Hit(&shadow, RAY_EPSILON, distance - RAY_EPSILON, &occluder)
+}
+
+// Light from the environment scattered at a hit, estimated by sampling a
+// direction towards the environment and tracing a shadow ray.
+func sampleEnvironment(r *cgm.Ray, rec *cgm.HitRecord, mat cgm.BsdfMaterial, scene *cgm.Scene) *cgm.Color {
+    dir, radiance, lightPdf := scene.Background.Sample()
+    if lightPdf <= 0 || radiance == (cgm.Color{}) {
+        return &cgm.Color{}
+    }
+    f, bsdfPdf := mat.Eval(r, rec, &dir)
+    if bsdfPdf <= 0 || occluded(r, &rec.P, &dir, math.Inf(1), scene.World) {
+        return &cgm.Color{}
+    }
+    return f.Mul(&radiance).Scale(powerHeuristic(lightPdf, bsdfPdf) / lightPdf)
+}
+
+// Light from the lights without geometry scattered at a hit. Scattered rays
+// never reach them, so their samples need no weighting.
+func sampleLights(r *cgm.Ray, rec *cgm.HitRecord, mat cgm.BsdfMaterial, scene *cgm.Scene) *cgm.Color {
+    direct := &cgm.Color{}
+    for _, light := range scene.Lights {
+        sample := light.Sample(&rec.P)
+        if sample.Pdf <= 0 || sample.Radiance == (cgm.Color{}) {
+            continue
+        }
+        f, _ := mat.Eval(r, rec, &sample.Dir)
+        if f == (cgm.Color{}) || occluded(r, &rec.P, &sample.Dir, sample.Distance, scene.World) {
+            continue
+        }
+        direct.Accumulate(f.Mul(&sample.Radiance).Scale(1 / sample.Pdf))
+    }
+    return direct
+}
+
+// Radiance along the ray. At materials that can be evaluated the lights and
+// the environment are also sampled directly, and bsdfPdf is the density with
+// which the material picked the ray, used to weight the environment when
+// the ray escapes. It is zero for camera rays and after specular bounces.
+func rayColor(r *cgm.Ray, scene *cgm.Scene, depth int, bsdfPdf float64) *cgm.Color {
     // If we exceeded the ray bounce limit, no more light is gathered.
     if depth <= 0 {
         return &cgm.Color{R: 0, G: 0, B: 0}
     }
 
     var rec cgm.HitRecord
-    if !world.Hit(r, RAY_EPSILON, math.Inf(1), &rec) {
-        return background
+    if !scene.World.Hit(r, RAY_EPSILON, math.Inf(1), &rec) {
+        radiance := scene.Background.Radiance(&r.Dir)
+        if bsdfPdf > 0 {
+            return radiance.Scale(powerHeuristic(bsdfPdf, scene.Background.Pdf(&r.Dir)))
+        }
+        return &radiance
     }
+    rec.ComputeDifferentials(r)
 
     var scattered cgm.Ray
     var attenuation cgm.Color
-    emitted := rec.Material.Emitted(rec.U, rec.V, &rec.P)
+    emitted := rec.Material.Emitted(r, &rec)
     if !rec.Material.Scatter(r, &rec, &attenuation, &scattered) {
         return emitted
     }
 
-    return emitted.Add(attenuation.Mul(rayColor(&scattered, background, world, depth - 1)))
+    scatteredPdf := 0.0
+    if bsdf, ok := rec.Material.(cgm.BsdfMaterial); ok {
+        emitted = emitted.Add(sampleEnvironment(r, &rec, bsdf, scene))
+        emitted = emitted.Add(sampleLights(r, &rec, bsdf, scene))
+        _, scatteredPdf = bsdf.Eval(r, &rec, scattered.Dir.UnitVector())
+    }
+
+    return emitted.Add(attenuation.Mul(rayColor(&scattered, scene, depth - 1, scatteredPdf)))
+}
+
+// Same as rayColor, but carrying radiance at a set of wavelengths instead of
+// RGB. The environment is only sampled by scattering, the lights without
+// geometry are sampled in RGB and upsampled.
+func rayColorSpectral(r *cgm.Ray, lambdas *cgm.SampledWavelengths, scene *cgm.Scene, depth int) *cgm.SampledSpectrum {
+    if depth <= 0 {
+        black := cgm.MakeConstantSpectrum(0)
+        return &black
+    }
+
+    var rec cgm.HitRecord
+    if !scene.World.Hit(r, RAY_EPSILON, math.Inf(1), &rec) {
+        radiance := scene.Background.Radiance(&r.Dir)
+        s := cgm.RgbUnboundedSpectrum(&radiance, lambdas)
+        return &s
+    }
+    rec.ComputeDifferentials(r)
+
+    var scattered cgm.Ray
+    var attenuation cgm.SampledSpectrum
+    emitted := cgm.EmittedSpectral(rec.Material, r, &rec, lambdas)
+    if !cgm.ScatterSpectral(rec.Material, r, &rec, lambdas, &attenuation, &scattered) {
+        return &emitted
+    }
+
+    if bsdf, ok := rec.Material.(cgm.BsdfMaterial); ok && len(scene.Lights) > 0 {
+        direct := cgm.RgbUnboundedSpectrum(sampleLights(r, &rec, bsdf, scene), lambdas)
+        emitted = *emitted.Add(&direct)
+    }
+
+    return emitted.Add(attenuation.Mul(rayColorSpectral(&scattered, lambdas, scene, depth - 1)))
+}
+
+// Trace one camera ray, either in RGB or with hero wavelength sampling.
+func sampleColor(r *cgm.Ray, scene *cgm.Scene, depth int, spectral bool) *cgm.Color {
+    if !spectral {
+        return rayColor(r, scene, depth, 0)
+    }
+    // The path keeps all wavelengths until a dispersive event restricts it
+    // to the hero wavelength.
+    lambdas := cgm.SampleHeroWavelengths()
+    c := rayColorSpectral(r, &lambdas, scene, depth).ToRgb(&lambdas)
+    return &c
 }
 
 func main() {
@@ -166,46 +291,50 @@ func main() {
     imageWidth := 400
     samplesPerPixel := 100
     maxDepth := 50
+    spectral := false
+    output := cgm.MakeOutputPipeline()
 
     // World
     var world cgm.Hittable
-    background := cgm.Color{0, 0, 0}
+    var lights []cgm.Light
+    var background cgm.Environment = &cgm.ConstantBackground{}
     var lookFrom cgm.Vec3
     var lookAt cgm.Vec3
     vfov := 40.0
     aperture := 0.0
+    var settings *cgm.CameraSettings
 
-    scene := 5
-    switch scene {
+    sceneNumber := 5
+    switch sceneNumber {
         case 0:
             world = randomScene()
-            background = cgm.Color{0.7, 0.8, 1.0}
+            background = &cgm.ConstantBackground{Color: cgm.Color{R: 0.7, G: 0.8, B: 1.0}}
             lookFrom = cgm.Vec3{13, 2, 3}
             lookAt = cgm.Vec3{0, 0, 0}
             vfov = 20.0
             aperture = 0.1
         case 1:
             world = twoSpheres()
-            background = cgm.Color{0.7, 0.8, 1.0}
+            background = &cgm.ConstantBackground{Color: cgm.Color{R: 0.7, G: 0.8, B: 1.0}}
             lookFrom = cgm.Vec3{X: 13, Y: 2, Z: 3}
             lookAt = cgm.Vec3{X: 0, Y: 0, Z: 0}
             vfov = 20.0
         case 2:
             world = twoPerlinSpheres()
-            background = cgm.Color{0.7, 0.8, 1.0}
+            background = &cgm.ConstantBackground{Color: cgm.Color{R: 0.7, G: 0.8, B: 1.0}}
             lookFrom = cgm.Vec3{13, 2, 3}
             lookAt = cgm.Vec3{X: 0, Y: 0, Z: 0}
             vfov = 20.0
         case 3:
             world = earth()
-            background = cgm.Color{0.7, 0.8, 1.0}
+            background = &cgm.ConstantBackground{Color: cgm.Color{R: 0.7, G: 0.8, B: 1.0}}
             lookFrom = cgm.Vec3{13, 2, 3}
             lookAt = cgm.Vec3{X: 0, Y: 0, Z: 0}
             vfov = 20.0
         case 4:
             world = simpleLight()
             samplesPerPixel = 400
-            background = cgm.Color{0, 0, 0}
+            background = &cgm.ConstantBackground{}
             lookFrom = cgm.Vec3{26, 3, 6}
             lookAt = cgm.Vec3{X: 0, Y: 2, Z: 0}
             vfov = 20.0
@@ -214,24 +343,65 @@ func main() {
             aspectRatio = 1.0
             imageWidth = 600
             samplesPerPixel = 200
-            background = cgm.Color{0, 0, 0}
+            background = &cgm.ConstantBackground{}
             lookFrom = cgm.Vec3{278, 278, -800}
             lookAt = cgm.Vec3{278, 278, 0}
             vfov = 40.0
+            // Roll off towards the radiance of the exposed light instead of
+            // clipping it.
+            output.Exposure = 1
+            output.ToneMapper = &cgm.ReinhardToneMapper{WhitePoint: 30}
+        case 6:
+            world = outdoorScene()
+            sky := cgm.MakePreethamSky(30, 135, 3, cgm.Color{0.5, 0.5, 0.5})
+            // From kcd/m² to cd/m², exposed like a photograph by daylight.
+            sky.Intensity = 1000
+            background = sky
+            lookFrom = cgm.Vec3{0, 2, 12}
+            lookAt = cgm.Vec3{0, 1, 0}
+            daylight := cgm.MakeCameraSettings()
+            daylight.FocalLength = 35
+            daylight.SensorHeight = daylight.SensorWidth / aspectRatio
+            daylight.FStop = 16
+            daylight.ShutterSpeed = 1.0 / 100
+            settings = &daylight
+            output.ToneMapper = &cgm.AgxToneMapper{}
+        case 7:
+            world = outdoorScene()
+            lights = []cgm.Light{
+                cgm.MakeSpotLight(cgm.Vec3{0, 8, 4}, cgm.Vec3{0, 0, 0}, cgm.Color{80, 75, 60}, 15, 25),
+                &cgm.PointLight{Position: cgm.Vec3{-4, 3, 4}, Intensity: cgm.Color{4, 6, 10}},
+                &cgm.DirectionalLight{Direction: cgm.Vec3{1, 1, 0.5}, Irradiance: cgm.Color{0.3, 0.2, 0.1}, AngularDiameter: 5},
+            }
+            lookFrom = cgm.Vec3{0, 2, 12}
+            lookAt = cgm.Vec3{0, 1, 0}
+            vfov = 30.0
+            output.ToneMapper = &cgm.AgxToneMapper{}
     }
 
     // Camera
     vUp := cgm.Vec3{X: 0, Y: 1, Z: 0}
     distToFocus := 10.0
-    cam := cgm.MakeCamera(&lookFrom, &lookAt, &vUp, vfov, aspectRatio, aperture, distToFocus, 0.0, 1.0)
+    transform := cgm.MakeLookAt(&lookFrom, &lookAt, &vUp)
+    var perspective *cgm.PerspectiveCamera
+    if settings != nil {
+        perspective = cgm.MakePhysicalCamera(transform, settings, distToFocus, 0.0)
+        output.Exposure += math.Log2(settings.ExposureScale())
+    } else {
+        perspective = cgm.MakePerspectiveCamera(transform, vfov, aspectRatio, aperture, distToFocus)
+    }
+    var cam cgm.Camera = perspective
     imageHeight := int(float64(imageWidth) / aspectRatio)
+    cam.SetImageSize(imageWidth, imageHeight)
 
-    bvh := cgm.MakeBvh([]cgm.Hittable{world}, 0.0, 1.0)
+    scene := &cgm.Scene{
+        World: cgm.MakeBvh([]cgm.Hittable{world}, 0.0, 1.0),
+        Lights: lights,
+        Background: background,
+    }
 
     // Render
-    fmt.Printf("P3\n") 
-    fmt.Printf("%d %d\n", imageWidth, imageHeight)
-    fmt.Printf("255\n") 
+    fb := cgm.MakeFramebuffer(imageWidth, imageHeight)
 
     for j := imageHeight - 1; j >= 0; j-- {
         fmt.Fprintf(os.Stderr, "\rScanlines remaining: %d", j)
@@ -240,12 +410,14 @@ func main() {
             for s := 0; s < samplesPerPixel; s++ {
                 u := (float64(i) + cgm.Rand()) / float64(imageWidth - 1)
                 v := (float64(j) + cgm.Rand()) / float64(imageHeight - 1)
-                r := cam.MakeRay(u, v)
-                pixelColor.Accumulate(rayColor(&r, &background, bvh, maxDepth))
+                if r, ok := cam.MakeRay(u, v); ok {
+                    pixelColor.Accumulate(sampleColor(&r, scene, maxDepth, spectral))
+                }
             }
-            cgm.WriteColor(os.Stdout, &pixelColor, samplesPerPixel)
+            fb.Set(i, imageHeight - 1 - j, pixelColor.Scale(1.0 / float64(samplesPerPixel)))
         }
     }
+    fb.WritePpm(os.Stdout, output)
     fmt.Fprintf(os.Stderr, "\nDone.\n")
 
     renderDuration := time.Since(startTime)
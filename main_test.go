@@ -0,0 +1,59 @@
+package main
+
+import (
+    "math"
+    "testing"
+
+    cgm "raytracer/cgmath"
+)
+
+func TestSampleLightsThroughWrappers(t *testing.T) {
+    grey := &cgm.Lambertian{Albedo: cgm.MakeSolidColor(0.5, 0.5, 0.5)}
+    metal := &cgm.Metal{Albedo: cgm.Color{0.9, 0.9, 0.9}}
+    amount := func(w float64) cgm.Texture {
+        return cgm.MakeSolidColor(w, w, w)
+    }
+
+    tests := []struct {
+        name string
+        material cgm.Material
+        // Expected direct light relative to the plain Lambertian.
+        scale float64
+    }{
+        {"lambertian", grey, 1},
+        {"mixed lambertians", &cgm.MixMaterial{A: grey, B: grey, Amount: amount(0.3)}, 1},
+        {"mixed with metal", &cgm.MixMaterial{A: grey, B: metal, Amount: amount(0.25)}, 0.75},
+        {"all metal mix", &cgm.MixMaterial{A: grey, B: metal, Amount: amount(1)}, 0},
+        {"flat bump map", cgm.MakeBumpMap(grey, cgm.MakeSolidColor(1, 1, 1), 0.1), 1},
+        // Through the coat at normal incidence both ways, with Schlick's
+        // reflectance of 0.04 for an index of 1.5.
+        {"coated", &cgm.CoatedMaterial{Base: grey, RefractiveIndex: 1.5}, 0.96 * 0.96},
+        {"metal", metal, 0},
+    }
+
+    // A point light straight above the top of a sphere, seen from above.
+    light := &cgm.PointLight{Position: cgm.Vec3{0, 3, 0}, Intensity: cgm.Color{4, 4, 4}}
+    // Albedo / π times the intensity over the squared distance of 2.
+    lambertian := 0.5 / math.Pi * 4 / (2 * 2)
+    for _, tt := range tests {
+        scene := &cgm.Scene{
+            World: &cgm.Sphere{Center: cgm.Vec3{0, 0, 0}, Radius: 1, Material: tt.material},
+            Lights: []cgm.Light{light},
+            Background: &cgm.ConstantBackground{},
+        }
+        r := cgm.Ray{Orig: cgm.Vec3{0, 5, 0}, Dir: cgm.Vec3{0, -1, 0}}
+        var rec cgm.HitRecord
+        if !scene.World.Hit(&r, RAY_EPSILON, math.Inf(1), &rec) {
+            t.Fatalf("%s: ray misses", tt.name)
+        }
+
+        var direct cgm.Color
+        if bsdf, ok := rec.Material.(cgm.BsdfMaterial); ok {
+            direct = *sampleLights(&r, &rec, bsdf, scene)
+        }
+        want := lambertian * tt.scale
+        if math.Abs(direct.R - want) > 1e-3 * lambertian {
+            t.Errorf("%s: direct light %g, want %g", tt.name, direct.R, want)
+        }
+    }
+}